@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ipFilter decides which client addresses may use the server.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// permits reports whether ip may be served. Deny ranges win over allow
+// ranges, and a non-empty allow list rejects every address outside it.
+func (f ipFilter) permits(ip net.IP) bool {
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+// withIPFilter answers 403 to clients whose address filter does not permit.
+// Behind -trusted-proxies the forwarded client address is checked.
+func withIPFilter(next fasthttp.RequestHandler, filter ipFilter) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !filter.permits(clientIP(ctx)) {
+			ctx.Error("Forbidden", fasthttp.StatusForbidden)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// parseCIDRs parses a comma separated list of CIDR ranges. A bare address
+// is taken as a range holding just that address.
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether any of nets contains ip.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestIPFilterPermits(t *testing.T) {
+	mustParse := func(list string) []*net.IPNet {
+		nets, err := parseCIDRs(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return nets
+	}
+
+	for _, tc := range []struct {
+		name        string
+		allow, deny string
+		ip          string
+		want        bool
+	}{
+		{"no lists", "", "", "203.0.113.7", true},
+		{"allow hit", "10.0.0.0/8", "", "10.1.2.3", true},
+		{"allow miss", "10.0.0.0/8", "", "192.168.1.1", false},
+		{"deny hit", "", "192.168.0.0/16", "192.168.1.1", false},
+		{"deny miss", "", "192.168.0.0/16", "10.1.2.3", true},
+		{"deny beats allow", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3", false},
+		{"allow outside deny", "10.0.0.0/8", "10.1.0.0/16", "10.2.0.1", true},
+		{"bare address", "127.0.0.1", "", "127.0.0.1", true},
+		{"bare address miss", "127.0.0.1", "", "127.0.0.2", false},
+		{"ipv6 range", "2001:db8::/32", "", "2001:db8::1", true},
+		{"ipv4-mapped ipv6", "10.0.0.0/8", "", "::ffff:10.1.2.3", true},
+		{"ipv4 range skips ipv6", "10.0.0.0/8", "", "2001:db8::1", false},
+	} {
+		f := ipFilter{allow: mustParse(tc.allow), deny: mustParse(tc.deny)}
+		if got := f.permits(net.ParseIP(tc.ip)); got != tc.want {
+			t.Errorf("%s: permits(%s) = %v, want %v", tc.name, tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestParseCIDRsInvalid(t *testing.T) {
+	for _, list := range []string{"10.0.0.0/33", "not-an-ip", "10.0.0.0/8,bogus"} {
+		if _, err := parseCIDRs(list); err == nil {
+			t.Errorf("parseCIDRs(%q) accepted it", list)
+		}
+	}
+}
+
+func TestIPFilterForbidden(t *testing.T) {
+	// In-memory connections come from 0.0.0.0
+	deny, err := parseCIDRs("0.0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testClient(t, withIPFilter(okHandler, ipFilter{deny: deny}))
+	if resp := fetch(t, c, fasthttp.MethodGet, "/"); resp.StatusCode() != fasthttp.StatusForbidden {
+		t.Errorf("denied client: status %d, want 403", resp.StatusCode())
+	}
+}
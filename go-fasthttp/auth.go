@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/base64"
+
+	"github.com/valyala/fasthttp"
+)
+
+// withBasicAuth requires HTTP Basic credentials matching user and pass for
+// every request whose path starts with prefix. Other paths pass straight
+// through to next.
+func withBasicAuth(next fasthttp.RequestHandler, prefix, user, pass string) fasthttp.RequestHandler {
+	prefixBytes := []byte(prefix)
+	wantUser, wantPass := []byte(user), []byte(pass)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		if !bytes.HasPrefix(ctx.Path(), prefixBytes) {
+			next(ctx)
+			return
+		}
+
+		gotUser, gotPass, ok := basicAuth(ctx.Request.Header.Peek(fasthttp.HeaderAuthorization))
+		// Evaluate both comparisons so timing doesn't reveal which one failed
+		userOK := subtle.ConstantTimeCompare(gotUser, wantUser)
+		passOK := subtle.ConstantTimeCompare(gotPass, wantPass)
+		if !ok || userOK&passOK != 1 {
+			ctx.Error("Unauthorized", fasthttp.StatusUnauthorized)
+			ctx.Response.Header.Set(fasthttp.HeaderWWWAuthenticate, `Basic realm="Restricted", charset="UTF-8"`)
+			return
+		}
+		next(ctx)
+	}
+}
+
+// basicAuth extracts the credentials from a Basic Authorization header.
+func basicAuth(header []byte) (user, pass []byte, ok bool) {
+	const scheme = "Basic "
+	if len(header) < len(scheme) || !bytes.EqualFold(header[:len(scheme)], []byte(scheme)) {
+		return nil, nil, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(header[len(scheme):]))
+	if err != nil {
+		return nil, nil, false
+	}
+	user, pass, ok = bytes.Cut(decoded, []byte(":"))
+	return user, pass, ok
+}
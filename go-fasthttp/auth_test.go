@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestBasicAuth(t *testing.T) {
+	c := testClient(t, withBasicAuth(okHandler, "/admin", "alice", "s3cret"))
+	basic := func(credentials string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials))
+	}
+
+	for _, tc := range []struct {
+		name, path, auth string
+		status           int
+	}{
+		{"missing", "/admin/x", "", fasthttp.StatusUnauthorized},
+		{"wrong password", "/admin/x", basic("alice:nope"), fasthttp.StatusUnauthorized},
+		{"wrong user", "/admin/x", basic("bob:s3cret"), fasthttp.StatusUnauthorized},
+		{"no colon", "/admin/x", basic("alices3cret"), fasthttp.StatusUnauthorized},
+		{"not base64", "/admin/x", "Basic !!!", fasthttp.StatusUnauthorized},
+		{"other scheme", "/admin/x", "Bearer abc", fasthttp.StatusUnauthorized},
+		{"correct", "/admin/x", basic("alice:s3cret"), fasthttp.StatusOK},
+		{"lower-case scheme", "/admin/x", "basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret")), fasthttp.StatusOK},
+		{"outside the prefix", "/public", "", fasthttp.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var header []string
+			if tc.auth != "" {
+				header = []string{fasthttp.HeaderAuthorization, tc.auth}
+			}
+			resp := fetch(t, c, fasthttp.MethodGet, tc.path, header...)
+			if resp.StatusCode() != tc.status {
+				t.Errorf("status %d, want %d", resp.StatusCode(), tc.status)
+			}
+			challenge := string(resp.Header.Peek(fasthttp.HeaderWWWAuthenticate))
+			if want := tc.status == fasthttp.StatusUnauthorized; (challenge != "") != want {
+				t.Errorf("WWW-Authenticate = %q, want one only on 401", challenge)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// benchStats summarises a -bench run.
+type benchStats struct {
+	Requests int
+	// Errors counts requests that failed outright or got a 4xx or 5xx
+	Errors  int
+	Bytes   int64
+	Elapsed time.Duration
+	// Latencies holds one duration per request, sorted ascending
+	Latencies []time.Duration
+}
+
+// runBench serves h on a random loopback port and sends it total GET
+// requests from concurrency workers, cycling through paths.
+func runBench(h fasthttp.RequestHandler, paths []string, total, concurrency int) (*benchStats, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &fasthttp.Server{Handler: h, ErrorHandler: serverError}
+	go srv.Serve(ln)
+	defer srv.Shutdown()
+
+	client := &fasthttp.HostClient{Addr: ln.Addr().String(), MaxConns: concurrency}
+	var next atomic.Int64
+	var mu sync.Mutex
+	stats := &benchStats{Latencies: make([]time.Duration, 0, total)}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := fasthttp.AcquireRequest()
+			resp := fasthttp.AcquireResponse()
+			defer fasthttp.ReleaseRequest(req)
+			defer fasthttp.ReleaseResponse(resp)
+
+			// Collected per worker so the hot loop takes no lock
+			var latencies []time.Duration
+			var errors int
+			var bytes int64
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= total {
+					break
+				}
+				req.SetRequestURI("http://" + client.Addr + paths[i%len(paths)])
+				t := time.Now()
+				err := client.Do(req, resp)
+				latencies = append(latencies, time.Since(t))
+				if err != nil || resp.StatusCode() >= fasthttp.StatusBadRequest {
+					errors++
+				}
+				if err == nil {
+					bytes += int64(len(resp.Header.Header()) + len(resp.Body()))
+				}
+			}
+
+			mu.Lock()
+			stats.Latencies = append(stats.Latencies, latencies...)
+			stats.Errors += errors
+			stats.Bytes += bytes
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	stats.Elapsed = time.Since(start)
+	stats.Requests = len(stats.Latencies)
+	sort.Slice(stats.Latencies, func(i, j int) bool { return stats.Latencies[i] < stats.Latencies[j] })
+	return stats, nil
+}
+
+// rps returns the overall throughput in requests per second.
+func (s *benchStats) rps() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Requests) / s.Elapsed.Seconds()
+}
+
+// percentile returns the latency below which fraction p of requests fell,
+// using the nearest-rank method.
+func (s *benchStats) percentile(p float64) time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	// The smallest latency with at least fraction p of requests at or below it
+	rank := int(math.Ceil(p*float64(len(s.Latencies)))) - 1
+	rank = min(max(rank, 0), len(s.Latencies)-1)
+	return s.Latencies[rank]
+}
+
+// writeTo prints the stats as a short report.
+func (s *benchStats) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "Requests:     %d (%d errors) in %s\n", s.Requests, s.Errors, s.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Requests/sec: %.0f\n", s.rps())
+	fmt.Fprintf(w, "Latency:      p50 %s  p95 %s  p99 %s\n", s.percentile(0.50), s.percentile(0.95), s.percentile(0.99))
+	fmt.Fprintf(w, "Transferred:  %d bytes\n", s.Bytes)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRunBench(t *testing.T) {
+	h := func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/bad" {
+			notFound(ctx)
+			return
+		}
+		ctx.SetBodyString("ok")
+	}
+	stats, err := runBench(h, []string{"/ok", "/ok", "/bad"}, 30, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.Requests != 30 || len(stats.Latencies) != 30 {
+		t.Errorf("%d requests with %d latencies, want 30", stats.Requests, len(stats.Latencies))
+	}
+	if stats.Errors != 10 {
+		t.Errorf("%d errors, want the 10 requests for /bad", stats.Errors)
+	}
+	if least := int64(20 * len("HTTP/1.1 200 OK\r\n\r\nok")); stats.Bytes < least {
+		t.Errorf("%d bytes transferred, want at least %d", stats.Bytes, least)
+	}
+	if stats.Elapsed <= 0 || stats.rps() <= 0 {
+		t.Errorf("elapsed %s, %.0f requests/sec", stats.Elapsed, stats.rps())
+	}
+	if !sort.SliceIsSorted(stats.Latencies, func(i, j int) bool { return stats.Latencies[i] < stats.Latencies[j] }) {
+		t.Error("latencies not sorted")
+	}
+
+	var report bytes.Buffer
+	stats.writeTo(&report)
+	if !strings.HasPrefix(report.String(), "Requests:     30 (10 errors) in ") {
+		t.Errorf("report %q", report.String())
+	}
+}
+
+func TestBenchPercentile(t *testing.T) {
+	// latencies returns 1ms, 2ms, ... n ms, already sorted as runBench leaves them
+	latencies := func(n int) []time.Duration {
+		l := make([]time.Duration, n)
+		for i := range l {
+			l[i] = time.Duration(i+1) * time.Millisecond
+		}
+		return l
+	}
+	for _, tc := range []struct {
+		n    int
+		p    float64
+		want time.Duration
+	}{
+		{0, 0.5, 0},
+		{1, 0, 1 * time.Millisecond},
+		{1, 0.5, 1 * time.Millisecond},
+		{1, 1, 1 * time.Millisecond},
+		{2, 0.5, 1 * time.Millisecond},
+		{2, 0.51, 2 * time.Millisecond},
+		{3, 0.4, 2 * time.Millisecond},
+		{3, 0.99, 3 * time.Millisecond},
+		{10, 0, 1 * time.Millisecond},
+		{10, 0.91, 10 * time.Millisecond},
+		{10, 1, 10 * time.Millisecond},
+		{100, 0, 1 * time.Millisecond},
+		{100, 0.50, 50 * time.Millisecond},
+		{100, 0.95, 95 * time.Millisecond},
+		{100, 0.99, 99 * time.Millisecond},
+		{100, 1, 100 * time.Millisecond},
+	} {
+		s := &benchStats{Latencies: latencies(tc.n)}
+		if got := s.percentile(tc.p); got != tc.want {
+			t.Errorf("percentile(%v) of %d requests = %s, want %s", tc.p, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheRevalidateInterval is how long a cached file is served without
+// checking whether it changed on disk. It bounds the cache to one stat per
+// file per interval, however hot the file is.
+const cacheRevalidateInterval = time.Second
+
+// cacheEntry is one whole file held in a fileCache.
+type cacheEntry struct {
+	key         string
+	name        string
+	body        []byte
+	contentType string
+	modTime     time.Time
+	etag        string
+	// checked is when the file was last confirmed unchanged, in Unix nanoseconds
+	checked atomic.Int64
+}
+
+// fileCache is an LRU cache of static files keyed by tree and request
+// path, and bounded by the total size of the cached bodies. It is safe for
+// concurrent use.
+type fileCache struct {
+	capacity int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+// newFileCache returns an empty cache holding at most capacity bytes.
+func newFileCache(capacity int64) *fileCache {
+	return &fileCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the entry for key, or nil, marking it most recently used.
+func (c *fileCache) get(key string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+// add stores e, replacing any entry with the same key and evicting the
+// least recently used entries until it fits.
+func (c *fileCache) add(e *cacheEntry) {
+	n := int64(len(e.body))
+	if n > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		c.removeElement(el)
+	}
+	for c.size+n > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+	c.entries[e.key] = c.order.PushFront(e)
+	c.size += n
+}
+
+// remove drops e from the cache if it is still the entry for its key.
+func (c *fileCache) remove(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok && el.Value == e {
+		c.removeElement(el)
+	}
+}
+
+// removeFile drops every entry read from the named file, or from beneath
+// it when it is a directory. Several keys can share a file, such as a
+// directory and its index.
+func (c *fileCache) removeFile(name string) {
+	prefix := name + string(filepath.Separator)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.order.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*cacheEntry); e.name == name || strings.HasPrefix(e.name, prefix) {
+			c.removeElement(el)
+		}
+		el = next
+	}
+}
+
+// free returns how many more bytes the cache can hold without evicting.
+func (c *fileCache) free() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity - c.size
+}
+
+func (c *fileCache) removeElement(el *list.Element) {
+	e := c.order.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.size -= int64(len(e.body))
+}
+
+// cacheKey is the cache key for reqPath. It is qualified by the tree rather
+// than the URL prefix: a mount such as /assets and a file under
+// public/assets share URL paths, but not files. The NUL separator cannot
+// occur in either part.
+func (s *staticServer) cacheKey(reqPath string) string {
+	return s.files.id() + "\x00" + reqPath
+}
+
+// cached returns the cache entry for key if it is still current,
+// re-checking the file's modification time once per revalidate interval.
+func (s *staticServer) cached(key string) *cacheEntry {
+	e := s.cache.get(key)
+	if e == nil {
+		return nil
+	}
+
+	now := time.Now().UnixNano()
+	if now-e.checked.Load() < int64(cacheRevalidateInterval) {
+		return e
+	}
+	info, err := s.files.stat(e.name)
+	if err != nil || !info.ModTime().Equal(e.modTime) || info.Size() != int64(len(e.body)) {
+		s.cache.remove(e)
+		return nil
+	}
+	e.checked.Store(now)
+	return e
+}
+
+// load reads the named file into the cache under key.
+func (s *staticServer) load(key, name string, info fs.FileInfo) (*cacheEntry, error) {
+	f, err := s.files.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	body, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	ct := contentType(name)
+	if ct == "application/octet-stream" {
+		ct = http.DetectContentType(body)
+	}
+	e := &cacheEntry{
+		key:         key,
+		name:        name,
+		body:        body,
+		contentType: ct,
+		modTime:     info.ModTime(),
+		etag:        fileETag(info),
+	}
+	e.checked.Store(time.Now().UnixNano())
+	s.cache.add(e)
+	return e, nil
+}
+
+// preload walks the whole tree and loads every file into the cache,
+// skipping files that no longer fit so nothing preloaded is evicted. An
+// index file is also cached under its directory's path. It returns the
+// number and total size of the files loaded.
+func (s *staticServer) preload() (files int, size int64, err error) {
+	err = fs.WalkDir(s.files.tree(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		keys := []string{"/" + p}
+		if path.Base(p) == indexFile {
+			dir := path.Dir("/" + p)
+			keys = append(keys, strings.TrimSuffix(dir, "/")+"/")
+		}
+
+		loaded := false
+		for _, key := range keys {
+			// Resolve as a request would, so symlinks out of the root are skipped
+			name, err := s.files.resolve(key)
+			if err != nil {
+				continue
+			}
+			info, err := s.files.stat(name)
+			if err != nil {
+				continue
+			}
+			if info.Size() > s.cache.free() {
+				continue
+			}
+			if _, err := s.load(s.cacheKey(key), name, info); err != nil {
+				continue
+			}
+			if !loaded {
+				loaded = true
+				files++
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return files, size, err
+}
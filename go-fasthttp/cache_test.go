@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestPreload(t *testing.T) {
+	s, _ := testStatic(t, map[string]string{
+		"a.txt":          "hello",
+		"sub/index.html": "<p>index</p>",
+		"big.txt":        strings.Repeat("x", 200),
+	})
+	s.cache = newFileCache(100)
+
+	files, size, err := s.preload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files != 2 || size != int64(len("hello")+len("<p>index</p>")) {
+		t.Errorf("preload loaded %d files of %d bytes, want 2 of %d", files, size, len("hello")+len("<p>index</p>"))
+	}
+
+	// Nothing has been requested yet, so these were all loaded up front
+	for key, want := range map[string]string{
+		"/a.txt":          "hello",
+		"/sub/index.html": "<p>index</p>",
+		"/sub/":           "<p>index</p>",
+	} {
+		e := s.cache.get(s.cacheKey(key))
+		if e == nil {
+			t.Errorf("%s not cached", key)
+			continue
+		}
+		if string(e.body) != want {
+			t.Errorf("%s cached as %q, want %q", key, e.body, want)
+		}
+	}
+	if s.cache.get(s.cacheKey("/big.txt")) != nil {
+		t.Error("/big.txt cached though it exceeds the cache size")
+	}
+}
+
+func TestPreloadOverlappingRoots(t *testing.T) {
+	public, _ := testStatic(t, map[string]string{"assets/x.txt": "public"})
+	mounted, _ := testStatic(t, map[string]string{"x.txt": "mounted"})
+	mounted.prefix = "/assets"
+	cache := newFileCache(1 << 20)
+	public.cache, mounted.cache = cache, cache
+	for _, s := range []*staticServer{public, mounted} {
+		if _, _, err := s.preload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both trees answer the URL /assets/x.txt once the mount's prefix is
+	// stripped, each from its own file
+	for _, tc := range []struct {
+		s             *staticServer
+		reqPath, want string
+	}{
+		{public, "/assets/x.txt", "public"},
+		{mounted, "/x.txt", "mounted"},
+	} {
+		c := testClient(t, func(ctx *fasthttp.RequestCtx) {
+			if !tc.s.serve(ctx, tc.reqPath) {
+				notFound(ctx)
+			}
+		})
+		if body := string(fetch(t, c, fasthttp.MethodGet, "/").Body()); body != tc.want {
+			t.Errorf("%s under %q: body %q, want %q", tc.reqPath, tc.s.prefix, body, tc.want)
+		}
+	}
+}
+
+func TestFileCacheConcurrent(t *testing.T) {
+	c := newFileCache(1000)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := "/" + strconv.Itoa(i%20)
+				switch i % 4 {
+				case 0, 1:
+					c.add(&cacheEntry{key: key, name: key, body: make([]byte, 10+i%90)})
+				case 2:
+					if e := c.get(key); e != nil && e.key != key {
+						t.Errorf("get(%q) returned %q", key, e.key)
+					}
+				case 3:
+					if w%2 == 0 {
+						c.removeFile(key)
+					} else if e := c.get(key); e != nil {
+						c.remove(e)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	var size int64
+	for key, el := range c.entries {
+		e := el.Value.(*cacheEntry)
+		if e.key != key {
+			t.Errorf("entry %q stored under %q", e.key, key)
+		}
+		size += int64(len(e.body))
+	}
+	if size != c.size || c.size > c.capacity || c.order.Len() != len(c.entries) {
+		t.Errorf("cache holds %d bytes in %d entries, %d listed; it counts %d of %d",
+			size, len(c.entries), c.order.Len(), c.size, c.capacity)
+	}
+}
+
+func BenchmarkStaticFile(b *testing.B) {
+	dir := b.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "page.html"), []byte(strings.Repeat("<p>benchmark</p>\n", 512)), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	root, err := staticRoot(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bc := range []struct {
+		name  string
+		cache *fileCache
+	}{
+		{"uncached", nil},
+		{"cached", newFileCache(1 << 20)},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			s := &staticServer{files: diskFiles{root: root}, cache: bc.cache, cacheControl: "public, max-age=3600"}
+			var ctx fasthttp.RequestCtx
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				ctx.Request.SetRequestURI("/page.html")
+				ctx.Response.Reset()
+				s.serve(&ctx, "/page.html")
+				if ctx.Response.StatusCode() != fasthttp.StatusOK {
+					b.Fatalf("status %d", ctx.Response.StatusCode())
+				}
+				// Read the body so the uncached file is actually sent
+				ctx.Response.Body()
+			}
+		})
+	}
+}
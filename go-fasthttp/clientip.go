@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// clientIPKey is the RequestCtx user value holding the resolved client IP.
+const clientIPKey = "clientIP"
+
+// withClientIP resolves the real client address of requests arriving
+// through a trusted proxy and stores it for clientIP.
+func withClientIP(next fasthttp.RequestHandler, trusted []*net.IPNet) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetUserValue(clientIPKey, forwardedClientIP(ctx, trusted))
+		next(ctx)
+	}
+}
+
+// clientIP returns the address of the client behind ctx: the one resolved
+// by withClientIP, or the direct peer when no proxy is trusted.
+func clientIP(ctx *fasthttp.RequestCtx) net.IP {
+	if ip, ok := ctx.UserValue(clientIPKey).(net.IP); ok {
+		return ip
+	}
+	return ctx.RemoteIP()
+}
+
+// forwardedClientIP walks X-Forwarded-For from the right, the end written
+// by the proxy nearest to us, and returns the first address that is not a
+// trusted proxy. Entries further left are ignored, since the client can
+// write anything there. A peer outside trusted is the client itself.
+func forwardedClientIP(ctx *fasthttp.RequestCtx, trusted []*net.IPNet) net.IP {
+	ip := ctx.RemoteIP()
+	if !containsIP(trusted, ip) {
+		return ip
+	}
+
+	// Proxies may add separate headers instead of extending one
+	headers := ctx.Request.Header.PeekAll(fasthttp.HeaderXForwardedFor)
+	for i := len(headers) - 1; i >= 0; i-- {
+		hops := strings.Split(string(headers[i]), ",")
+		for j := len(hops) - 1; j >= 0; j-- {
+			hop := net.ParseIP(strings.TrimSpace(hops[j]))
+			if hop == nil {
+				// A malformed entry ends the chain we can vouch for
+				return ip
+			}
+			ip = hop
+			if !containsIP(trusted, ip) {
+				return ip
+			}
+		}
+	}
+	// Every hop is trusted, so the leftmost one is the best we know
+	return ip
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestForwardedClientIP(t *testing.T) {
+	trusted, err := parseCIDRs("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		peer string
+		xff  []string
+		want string
+	}{
+		{"untrusted peer ignores the header", "203.0.113.7", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"untrusted peer spoofing a proxy", "203.0.113.7", []string{"10.0.0.1"}, "203.0.113.7"},
+		{"trusted peer without a header", "10.0.0.1", nil, "10.0.0.1"},
+		{"trusted peer", "10.0.0.1", []string{"198.51.100.2"}, "198.51.100.2"},
+		// The client wrote the leftmost entries; only our proxies' are kept
+		{"spoofed entries are skipped", "10.0.0.1", []string{"1.2.3.4, 198.51.100.2"}, "198.51.100.2"},
+		{"trusted hops are walked", "10.0.0.1", []string{"1.2.3.4, 198.51.100.2, 192.168.1.1, 10.9.9.9"}, "198.51.100.2"},
+		{"repeated headers", "10.0.0.1", []string{"1.2.3.4", "198.51.100.2", "10.0.0.2"}, "198.51.100.2"},
+		{"malformed entry ends the chain", "10.0.0.1", []string{"1.2.3.4, junk, 10.0.0.2"}, "10.0.0.2"},
+		{"every hop trusted", "10.0.0.1", []string{"10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"ipv6 client", "10.0.0.1", []string{"2001:db8::1"}, "2001:db8::1"},
+	} {
+		var req fasthttp.Request
+		for _, h := range tc.xff {
+			req.Header.Add(fasthttp.HeaderXForwardedFor, h)
+		}
+		var ctx fasthttp.RequestCtx
+		ctx.Init(&req, &net.TCPAddr{IP: net.ParseIP(tc.peer), Port: 40000}, nil)
+
+		if got := forwardedClientIP(&ctx, trusted); !got.Equal(net.ParseIP(tc.want)) {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
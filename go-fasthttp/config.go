@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the file form of the server settings, loaded with -config.
+// Keys match the flag names. Every field is optional: a field left out
+// keeps the flag default, and a flag given on the command line overrides
+// the file.
+type Config struct {
+	Port *int    `json:"port" yaml:"port"`
+	Addr *string `json:"addr" yaml:"addr"`
+	// Root is the directory served at /, in place of public
+	Root *string `json:"root" yaml:"root"`
+
+	ReadTimeout   *Duration `json:"read-timeout" yaml:"read-timeout"`
+	HeaderTimeout *Duration `json:"header-timeout" yaml:"header-timeout"`
+	WriteTimeout  *Duration `json:"write-timeout" yaml:"write-timeout"`
+	IdleTimeout   *Duration `json:"idle-timeout" yaml:"idle-timeout"`
+
+	Compress *bool `json:"compress" yaml:"compress"`
+
+	TLSCert       *string `json:"tls-cert" yaml:"tls-cert"`
+	TLSKey        *string `json:"tls-key" yaml:"tls-key"`
+	HTTPSPort     *int    `json:"https-port" yaml:"https-port"`
+	RedirectHTTPS *bool   `json:"redirect-https" yaml:"redirect-https"`
+}
+
+// Duration is a time.Duration written as a string such as "10s" in both
+// YAML and JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// loadConfig reads and validates the config file at path. Files ending in
+// .json are read as JSON and anything else as YAML. Unknown keys are an
+// error, so a typo does not silently fall back to a default.
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if filepath.Ext(path) == ".json" {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&c)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&c)
+	}
+	// An empty file decodes as io.EOF and sets nothing
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// validate checks the fields that are set. Settings that depend on each
+// other, such as tls-cert and tls-key, are checked with the flags once the
+// file has been applied.
+func (c *Config) validate() error {
+	if c.Port != nil && !validPort(*c.Port) {
+		return fmt.Errorf("port %d: must be between 1 and 65535", *c.Port)
+	}
+	if c.HTTPSPort != nil && !validPort(*c.HTTPSPort) {
+		return fmt.Errorf("https-port %d: must be between 1 and 65535", *c.HTTPSPort)
+	}
+	if c.Root != nil {
+		info, err := os.Stat(*c.Root)
+		if err != nil {
+			return fmt.Errorf("root: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("root %s: not a directory", *c.Root)
+		}
+	}
+	for name, d := range map[string]*Duration{
+		"read-timeout":   c.ReadTimeout,
+		"header-timeout": c.HeaderTimeout,
+		"write-timeout":  c.WriteTimeout,
+		"idle-timeout":   c.IdleTimeout,
+	} {
+		if d != nil && *d < 0 {
+			return fmt.Errorf("%s %s: must not be negative", name, time.Duration(*d))
+		}
+	}
+	return nil
+}
+
+// apply copies the fields that are set onto their flags, skipping flags
+// given on the command line. The exception is root, which is added to the
+// -root mappings on the command line when none of them is for /.
+func (c *Config) apply() error {
+	values := make(map[string]string)
+	if c.Port != nil {
+		values["port"] = strconv.Itoa(*c.Port)
+	}
+	if c.Addr != nil {
+		values["addr"] = *c.Addr
+	}
+	if c.Root != nil {
+		values["root"] = "/=" + *c.Root
+	}
+	if c.ReadTimeout != nil {
+		values["read-timeout"] = time.Duration(*c.ReadTimeout).String()
+	}
+	if c.HeaderTimeout != nil {
+		values["header-timeout"] = time.Duration(*c.HeaderTimeout).String()
+	}
+	if c.WriteTimeout != nil {
+		values["write-timeout"] = time.Duration(*c.WriteTimeout).String()
+	}
+	if c.IdleTimeout != nil {
+		values["idle-timeout"] = time.Duration(*c.IdleTimeout).String()
+	}
+	if c.Compress != nil {
+		values["compress"] = strconv.FormatBool(*c.Compress)
+	}
+	if c.TLSCert != nil {
+		values["tls-cert"] = *c.TLSCert
+	}
+	if c.TLSKey != nil {
+		values["tls-key"] = *c.TLSKey
+	}
+	if c.HTTPSPort != nil {
+		values["https-port"] = strconv.Itoa(*c.HTTPSPort)
+	}
+	if c.RedirectHTTPS != nil {
+		values["redirect-https"] = strconv.FormatBool(*c.RedirectHTTPS)
+	}
+
+	// Collect the command-line flags first, since Set marks flags as set
+	onCommandLine := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	// -root is repeatable, so the file's mapping for / joins the command
+	// line's unless one of those maps / too
+	if roots, ok := flag.Lookup("root").Value.(*stringList); ok && !mapsSiteRoot(*roots) {
+		onCommandLine["root"] = false
+	}
+	for name, value := range values {
+		if onCommandLine[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// mapsSiteRoot reports whether any of the -root specs maps the prefix /.
+func mapsSiteRoot(specs []string) bool {
+	for _, spec := range specs {
+		if prefix, _, _ := strings.Cut(spec, "="); strings.TrimRight(prefix, "/") == "" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configFlags replaces the command line flags with the ones Config sets,
+// parsed from args, until the test ends.
+func configFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Int("port", 8080, "")
+	fs.String("addr", "0.0.0.0", "")
+	fs.Var(&stringList{}, "root", "")
+	fs.Duration("read-timeout", 10*time.Second, "")
+	fs.Duration("header-timeout", 0, "")
+	fs.Duration("write-timeout", 10*time.Second, "")
+	fs.Duration("idle-timeout", time.Minute, "")
+	fs.Bool("compress", true, "")
+	fs.String("tls-cert", "", "")
+	fs.String("tls-key", "", "")
+	fs.Int("https-port", 0, "")
+	fs.Bool("redirect-https", false, "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	flag.CommandLine = fs
+	return fs
+}
+
+// writeConfig writes a config file named name holding body to a new
+// directory, along with a dist directory for it to name as root.
+func writeConfig(t *testing.T, name, body string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "dist"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	body = strings.ReplaceAll(body, "DIST", filepath.Join(dir, "dist"))
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const yamlConfig = `
+port: 9000
+addr: 127.0.0.1
+root: DIST
+read-timeout: 5s
+header-timeout: 2s
+write-timeout: 10s
+idle-timeout: 2m
+compress: false
+tls-cert: cert.pem
+tls-key: key.pem
+https-port: 9443
+redirect-https: true
+`
+
+const jsonConfig = `{
+	"port": 9000,
+	"addr": "127.0.0.1",
+	"root": "DIST",
+	"read-timeout": "5s",
+	"header-timeout": "2s",
+	"write-timeout": "10s",
+	"idle-timeout": "2m",
+	"compress": false,
+	"tls-cert": "cert.pem",
+	"tls-key": "key.pem",
+	"https-port": 9443,
+	"redirect-https": true
+}`
+
+func TestLoadConfig(t *testing.T) {
+	for name, body := range map[string]string{"server.yaml": yamlConfig, "server.json": jsonConfig} {
+		t.Run(name, func(t *testing.T) {
+			path := writeConfig(t, name, body)
+			c, err := loadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dist := filepath.Join(filepath.Dir(path), "dist")
+			if *c.Port != 9000 || *c.Addr != "127.0.0.1" || *c.Root != dist ||
+				time.Duration(*c.ReadTimeout) != 5*time.Second || time.Duration(*c.HeaderTimeout) != 2*time.Second ||
+				time.Duration(*c.WriteTimeout) != 10*time.Second || time.Duration(*c.IdleTimeout) != 2*time.Minute ||
+				*c.Compress || *c.TLSCert != "cert.pem" || *c.TLSKey != "key.pem" ||
+				*c.HTTPSPort != 9443 || !*c.RedirectHTTPS {
+				t.Errorf("decoded %+v", c)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, file, body, want string
+	}{
+		{"unknown yaml key", "c.yaml", "prot: 9000\n", "prot"},
+		{"unknown json key", "c.json", `{"prot": 9000}`, "prot"},
+		{"bad duration", "c.yaml", "read-timeout: soon\n", "soon"},
+		{"port out of range", "c.yaml", "port: 70000\n", "port 70000"},
+		{"negative timeout", "c.json", `{"idle-timeout": "-1s"}`, "idle-timeout"},
+		{"missing root", "c.yaml", "root: DIST/nope\n", "root"},
+		{"root not a directory", "c.yaml", "root: " + "DIST/../c.yaml\n", "not a directory"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, tc.file, tc.body))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %v, want one mentioning %q", err, tc.want)
+			}
+		})
+	}
+
+	t.Run("empty file", func(t *testing.T) {
+		c, err := loadConfig(writeConfig(t, "c.yaml", ""))
+		if err != nil || c.Port != nil {
+			t.Errorf("got %+v, %v; want an empty config", c, err)
+		}
+	})
+}
+
+func TestConfigApply(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		args  []string
+		port  string
+		roots []string
+	}{
+		{"file only", nil, "9000", []string{"/=DIST"}},
+		{"port flag wins", []string{"-port", "8081"}, "8081", []string{"/=DIST"}},
+		{"roots merge", []string{"-root", "/assets=./assets"}, "9000", []string{"/assets=./assets", "/=DIST"}},
+		{"root flag wins", []string{"-root", "/=./site"}, "9000", []string{"/=./site"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeConfig(t, "c.yaml", yamlConfig)
+			dist := filepath.Join(filepath.Dir(path), "dist")
+			fs := configFlags(t, tc.args...)
+			c, err := loadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := c.apply(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := fs.Lookup("port").Value.String(); got != tc.port {
+				t.Errorf("port = %s, want %s", got, tc.port)
+			}
+			if got := fs.Lookup("read-timeout").Value.String(); got != "5s" {
+				t.Errorf("read-timeout = %s, want the file's 5s", got)
+			}
+			var want []string
+			for _, root := range tc.roots {
+				want = append(want, strings.ReplaceAll(root, "DIST", dist))
+			}
+			if got := []string(*fs.Lookup("root").Value.(*stringList)); !reflect.DeepEqual(got, want) {
+				t.Errorf("roots = %q, want %q", got, want)
+			}
+		})
+	}
+}
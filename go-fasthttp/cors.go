@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// corsAllowMethods is advertised to preflight requests.
+const corsAllowMethods = "GET, HEAD, POST, OPTIONS"
+
+// corsPolicy decides which origins may read responses cross-origin.
+type corsPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+}
+
+// parseCORSOrigins parses the -cors-origins value: either "*" or a comma
+// separated list of origins such as "https://app.example.com".
+func parseCORSOrigins(list string) corsPolicy {
+	p := corsPolicy{origins: make(map[string]bool)}
+	for _, origin := range strings.Split(list, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+		case "*":
+			p.anyOrigin = true
+		default:
+			p.origins[origin] = true
+		}
+	}
+	return p
+}
+
+// allows reports whether origin may read responses.
+func (p corsPolicy) allows(origin []byte) bool {
+	return len(origin) > 0 && (p.anyOrigin || p.origins[string(origin)])
+}
+
+// setAllowOrigin adds the Access-Control-Allow-Origin header for a request
+// from an allowed origin.
+func (p corsPolicy) setAllowOrigin(ctx *fasthttp.RequestCtx, origin []byte) {
+	if p.anyOrigin {
+		ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowOrigin, "*")
+		return
+	}
+	ctx.Response.Header.SetBytesV(fasthttp.HeaderAccessControlAllowOrigin, origin)
+}
+
+// setVary marks the response as depending on the request's Origin. Under a
+// whitelist every response does, those without CORS headers included, or a
+// shared cache could hand one origin's response to another.
+func (p corsPolicy) setVary(ctx *fasthttp.RequestCtx) {
+	if !p.anyOrigin {
+		addVary(&ctx.Response.Header, "Origin")
+	}
+}
+
+// withCORS answers preflight requests and adds CORS headers to responses
+// for allowed origins. Requests from other origins are served unchanged, so
+// browsers refuse to expose the response to the calling page.
+func withCORS(next fasthttp.RequestHandler, policy corsPolicy) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		origin := ctx.Request.Header.Peek(fasthttp.HeaderOrigin)
+
+		if ctx.IsOptions() && len(ctx.Request.Header.Peek(fasthttp.HeaderAccessControlRequestMethod)) > 0 {
+			if !policy.allows(origin) {
+				ctx.Error("Forbidden", fasthttp.StatusForbidden)
+				policy.setVary(ctx)
+				return
+			}
+			policy.setVary(ctx)
+			policy.setAllowOrigin(ctx, origin)
+			ctx.Response.Header.Set(fasthttp.HeaderAccessControlAllowMethods, corsAllowMethods)
+			if headers := ctx.Request.Header.Peek(fasthttp.HeaderAccessControlRequestHeaders); len(headers) > 0 {
+				ctx.Response.Header.SetBytesV(fasthttp.HeaderAccessControlAllowHeaders, headers)
+			}
+			ctx.Response.Header.Set(fasthttp.HeaderAccessControlMaxAge, "86400")
+			ctx.SetStatusCode(fasthttp.StatusNoContent)
+			return
+		}
+
+		next(ctx)
+		// A 404 or 500 from next is still readable by an allowed origin, and
+		// its Vary must survive the header reset that came with it
+		if policy.allows(origin) {
+			policy.setAllowOrigin(ctx, origin)
+		}
+		policy.setVary(ctx)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCORS(t *testing.T) {
+	const allowed, other = "https://app.example.com", "https://evil.example.com"
+	preflight := func(origin string) []string {
+		return []string{
+			fasthttp.HeaderOrigin, origin,
+			fasthttp.HeaderAccessControlRequestMethod, "POST",
+			fasthttp.HeaderAccessControlRequestHeaders, "Content-Type",
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		origins     string
+		method      string
+		header      []string
+		status      int
+		allowOrigin string
+		varyOrigin  bool
+	}{
+		{"allowed origin", allowed, fasthttp.MethodGet, []string{fasthttp.HeaderOrigin, allowed}, fasthttp.StatusOK, allowed, true},
+		{"disallowed origin", allowed, fasthttp.MethodGet, []string{fasthttp.HeaderOrigin, other}, fasthttp.StatusOK, "", true},
+		{"no origin", allowed, fasthttp.MethodGet, nil, fasthttp.StatusOK, "", true},
+		{"allowed preflight", allowed, fasthttp.MethodOptions, preflight(allowed), fasthttp.StatusNoContent, allowed, true},
+		{"disallowed preflight", allowed, fasthttp.MethodOptions, preflight(other), fasthttp.StatusForbidden, "", true},
+		{"any origin", "*", fasthttp.MethodGet, []string{fasthttp.HeaderOrigin, other}, fasthttp.StatusOK, "*", false},
+		{"any origin preflight", "*", fasthttp.MethodOptions, preflight(other), fasthttp.StatusNoContent, "*", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testClient(t, withCORS(okHandler, parseCORSOrigins(tc.origins)))
+			resp := fetch(t, c, tc.method, "/", tc.header...)
+
+			if resp.StatusCode() != tc.status {
+				t.Errorf("status %d, want %d", resp.StatusCode(), tc.status)
+			}
+			if got := string(resp.Header.Peek(fasthttp.HeaderAccessControlAllowOrigin)); got != tc.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tc.allowOrigin)
+			}
+			vary := string(resp.Header.Peek(fasthttp.HeaderVary))
+			if strings.Contains(vary, "Origin") != tc.varyOrigin {
+				t.Errorf("Vary = %q, want Origin listed %v", vary, tc.varyOrigin)
+			}
+			if tc.status == fasthttp.StatusNoContent {
+				if got := string(resp.Header.Peek(fasthttp.HeaderAccessControlAllowMethods)); got != corsAllowMethods {
+					t.Errorf("Access-Control-Allow-Methods = %q", got)
+				}
+				if got := string(resp.Header.Peek(fasthttp.HeaderAccessControlAllowHeaders)); got != "Content-Type" {
+					t.Errorf("Access-Control-Allow-Headers = %q, want the requested headers", got)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSVaryKeepsCompression(t *testing.T) {
+	big := strings.Repeat("compressible text ", 400)
+	s, _ := testStatic(t, map[string]string{"big.txt": big})
+	c := testClient(t, withCORS(withCompression(staticHandler(s)), parseCORSOrigins("https://app.example.com")))
+
+	resp := fetch(t, c, fasthttp.MethodGet, "/big.txt", fasthttp.HeaderAcceptEncoding, "gzip")
+	if got := string(resp.Header.Peek(fasthttp.HeaderVary)); got != "Accept-Encoding, Origin" {
+		t.Errorf("Vary = %q, want both Accept-Encoding and Origin", got)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// delayRule holds requests under prefix for d before they are handled,
+// from a -delay flag.
+type delayRule struct {
+	// prefix has no trailing slash, so "/" is held as ""
+	prefix string
+	d      time.Duration
+}
+
+// parseDelays parses mappings of the form /prefix=duration. The result is
+// ordered longest prefix first, so the most specific rule matches.
+func parseDelays(specs []string) ([]delayRule, error) {
+	rules := make([]delayRule, 0, len(specs))
+	for _, spec := range specs {
+		prefix, value, ok := strings.Cut(spec, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "*") {
+			return nil, fmt.Errorf("invalid mapping %q: want /prefix=duration", spec)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping %q: %w", spec, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid mapping %q: duration must be positive", spec)
+		}
+		rules = append(rules, delayRule{prefix: strings.TrimRight(prefix, "/"), d: d})
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].prefix) > len(rules[j].prefix) })
+	return rules, nil
+}
+
+// delayFor returns the delay of the first rule whose prefix is path or a
+// parent of it, or 0 when none matches.
+func delayFor(rules []delayRule, path string) time.Duration {
+	for _, rule := range rules {
+		if path == rule.prefix || strings.HasPrefix(path, rule.prefix+"/") {
+			return rule.d
+		}
+	}
+	return 0
+}
+
+// disconnectPoll is how often a delayed request checks on its client.
+const disconnectPoll = 50 * time.Millisecond
+
+// statusClientClosed is recorded for requests whose client went away while
+// they were delayed, as nginx logs them.
+const statusClientClosed = 499
+
+// withDelay holds matching requests for their rule's delay before passing
+// them on, to simulate a slow backend. fasthttp closes ctx.Done() when the
+// server shuts down, which cuts the wait short with a 503 so shutdown is
+// not held up. fasthttp does not report client disconnects, so the
+// connection is checked every disconnectPoll and a request whose client
+// has gone is dropped with statusClientClosed.
+func withDelay(next fasthttp.RequestHandler, rules []delayRule) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if d := delayFor(rules, string(ctx.Path())); d > 0 {
+			timer := time.NewTimer(d)
+			defer timer.Stop()
+			poll := time.NewTicker(disconnectPoll)
+			defer poll.Stop()
+		wait:
+			for {
+				select {
+				case <-timer.C:
+					break wait
+				case <-ctx.Done():
+					ctx.Error("Service Unavailable", fasthttp.StatusServiceUnavailable)
+					return
+				case <-poll.C:
+					if clientGone(ctx.Conn()) {
+						ctx.Error("Client Closed Request", statusClientClosed)
+						ctx.SetConnectionClose()
+						return
+					}
+				}
+			}
+		}
+		next(ctx)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// clientGone always reports false: disconnects are only detected on Unix.
+func clientGone(net.Conn) bool {
+	return false
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDelayLatency(t *testing.T) {
+	rules, err := parseDelays([]string{"/slow=200ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testClient(t, withDelay(okHandler, rules))
+
+	for _, tc := range []struct {
+		path    string
+		delayed bool
+	}{
+		{"/slow", true},
+		{"/slow/x", true},
+		{"/slower", false},
+		{"/fast", false},
+	} {
+		start := time.Now()
+		resp := fetch(t, c, fasthttp.MethodGet, tc.path)
+		elapsed := time.Since(start)
+		if resp.StatusCode() != fasthttp.StatusOK {
+			t.Errorf("%s: status %d", tc.path, resp.StatusCode())
+		}
+		if delayed := elapsed >= 200*time.Millisecond; delayed != tc.delayed {
+			t.Errorf("%s took %s, want delayed %v", tc.path, elapsed, tc.delayed)
+		}
+	}
+}
+
+func TestDelayKeepsPipelinedRequest(t *testing.T) {
+	rules, err := parseDelays([]string{"/slow=200ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: withDelay(okHandler, rules)}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(countingListener{Listener: ln})
+	t.Cleanup(func() { srv.Shutdown() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The second request sits unread on the socket while the first waits
+	fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: test\r\n\r\nGET /slow HTTP/1.1\r\nHost: test\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	for i := 0; i < 2; i++ {
+		resp := &fasthttp.Response{}
+		if err := resp.Read(br); err != nil {
+			t.Fatalf("response %d: %v", i+1, err)
+		}
+		if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "ok" {
+			t.Errorf("response %d: status %d, body %q", i+1, resp.StatusCode(), resp.Body())
+		}
+	}
+}
+
+func TestParseDelaysInvalid(t *testing.T) {
+	for _, spec := range []string{"/slow", "slow=1s", "/slow=soon", "/slow=-1s"} {
+		if _, err := parseDelays([]string{spec}); err == nil {
+			t.Errorf("parseDelays(%q) accepted it", spec)
+		}
+	}
+}
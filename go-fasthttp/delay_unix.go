@@ -0,0 +1,31 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// clientGone reports whether the client at the other end of c has closed
+// its side. The socket is peeked without blocking, so a pipelined request
+// waiting to be read is left for fasthttp.
+func clientGone(c net.Conn) bool {
+	sc, ok := netConn(c).(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	gone := false
+	raw.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, _, err := syscall.Recvfrom(int(fd), b[:], syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		gone = (n == 0 && err == nil) || err == syscall.ECONNRESET
+		// Never wait for the socket to become readable
+		return true
+	})
+	return gone
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDelayClientGone(t *testing.T) {
+	rules, err := parseDelays([]string{"/slow=30s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(chan int, 1)
+	delayed := withDelay(okHandler, rules)
+	srv := &fasthttp.Server{Handler: func(ctx *fasthttp.RequestCtx) {
+		delayed(ctx)
+		statuses <- ctx.Response.StatusCode()
+	}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(countingListener{Listener: ln})
+	t.Cleanup(func() { srv.Shutdown() })
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(conn, "GET /slow HTTP/1.1\r\nHost: test\r\n\r\n")
+	// Let the request reach the handler before giving up on it
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case status := <-statuses:
+		if status != statusClientClosed {
+			t.Errorf("status %d, want %d", status, statusClientClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler still waiting after the client disconnected")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"html"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// serveListing writes an HTML index of the named directory, which reqPath
+// resolved to. Links are absolute so they work whether or not reqPath ends
+// in a slash.
+func (s *staticServer) serveListing(ctx *fasthttp.RequestCtx, reqPath, name string) {
+	entries, err := s.files.readDir(name)
+	if err != nil {
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return
+	}
+
+	dir := s.prefix + reqPath
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	title := html.EscapeString("Index of " + dir)
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>")
+	b.WriteString(title)
+	b.WriteString("</title></head>\n<body>\n<h1>")
+	b.WriteString(title)
+	b.WriteString("</h1>\n<table>\n<tr><th>Name</th><th>Size</th><th>Modified</th></tr>\n")
+	if reqPath != "/" && reqPath != "" {
+		parent := path.Dir(strings.TrimSuffix(dir, "/"))
+		b.WriteString("<tr><td><a href=\"" + html.EscapeString(escapePath(strings.TrimSuffix(parent, "/")+"/")) + "\">../</a></td><td></td><td></td></tr>\n")
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since it was listed
+			continue
+		}
+		label, size := entry.Name(), strconv.FormatInt(info.Size(), 10)
+		if entry.IsDir() {
+			label, size = label+"/", "-"
+		}
+		var modified string
+		if mt := info.ModTime(); !mt.IsZero() {
+			modified = mt.UTC().Format("2006-01-02 15:04:05")
+		}
+		href := escapePath(dir + label)
+		b.WriteString("<tr><td><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(label) + "</a></td>")
+		b.WriteString("<td>" + size + "</td><td>" + modified + "</td></tr>\n")
+	}
+	b.WriteString("</table>\n</body>\n</html>\n")
+
+	ctx.SetContentType("text/html; charset=utf-8")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetBodyString(b.String())
+}
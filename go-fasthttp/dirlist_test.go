@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestDirListing(t *testing.T) {
+	s, _ := testStatic(t, map[string]string{
+		"docs/a.txt":           "aaa",
+		"docs/with space.txt":  "b",
+		"docs/<b>&\"quote.txt": "c",
+		"docs/sub/nested.txt":  "d",
+		"docs/<script>/x.txt":  "e",
+		"withindex/index.html": "index",
+		"withindex/other.txt":  "f",
+	})
+	s.dirListing = true
+	c := testClient(t, staticHandler(s))
+
+	resp := fetch(t, c, fasthttp.MethodGet, "/docs/")
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Header.ContentType()) != "text/html; charset=utf-8" {
+		t.Fatalf("status %d, Content-Type %q", resp.StatusCode(), resp.Header.ContentType())
+	}
+	page := string(resp.Body())
+	for _, want := range []string{
+		"<title>Index of /docs/</title>",
+		`<a href="/">../</a>`,
+		`<a href="/docs/a.txt">a.txt</a></td><td>3</td>`,
+		`<a href="/docs/with%20space.txt">with space.txt</a>`,
+		`<a href="/docs/%3Cb%3E&amp;%22quote.txt">&lt;b&gt;&amp;&#34;quote.txt</a>`,
+		`<a href="/docs/sub/">sub/</a></td><td>-</td>`,
+		`<a href="/docs/%3Cscript%3E/">&lt;script&gt;/</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("listing lacks %s", want)
+		}
+	}
+	if strings.Contains(page, "<script>") || strings.Contains(page, "<b>") {
+		t.Error("listing contains an unescaped file name")
+	}
+	if t.Failed() {
+		t.Log(page)
+	}
+
+	if body := string(fetch(t, c, fasthttp.MethodGet, "/withindex/").Body()); body != "index" {
+		t.Errorf("directory with an index: body %q, want the index", body)
+	}
+	s.dirListing = false
+	if resp := fetch(t, c, fasthttp.MethodGet, "/docs/"); resp.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("listing disabled: status %d, want 404", resp.StatusCode())
+	}
+}
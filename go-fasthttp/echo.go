@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/valyala/fasthttp"
+)
+
+// echoPath is the cheap dynamic endpoint for load generators.
+const echoPath = "/api/echo"
+
+// serveEcho answers with a JSON object describing the request:
+//
+//	{"method":"POST","path":"/api/echo","query":{"a":"1","b":["2","3"]},"body":{"x":1},"time_ns":1700000000000000000}
+//
+// A body that is valid JSON is embedded as-is and any other body as a
+// string. Repeated query keys collect their values into an array. The
+// object is written straight into the response buffer.
+func serveEcho(ctx *fasthttp.RequestCtx) {
+	// Reuse the pooled response buffer, so a warm server allocates nothing
+	// for the body itself
+	b := ctx.Response.SwapBody(nil)[:0]
+
+	b = append(b, `{"method":`...)
+	b = appendJSONString(b, ctx.Method())
+	b = append(b, `,"path":`...)
+	b = appendJSONString(b, ctx.Path())
+
+	b = append(b, `,"query":{`...)
+	args := ctx.QueryArgs()
+	var done [][]byte
+	args.VisitAll(func(key, _ []byte) {
+		for _, k := range done {
+			if bytes.Equal(k, key) {
+				return
+			}
+		}
+		done = append(done, key)
+		if len(done) > 1 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, key)
+		b = append(b, ':')
+		values := args.PeekMultiBytes(key)
+		if len(values) == 1 {
+			b = appendJSONString(b, values[0])
+			return
+		}
+		b = append(b, '[')
+		for i, v := range values {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, v)
+		}
+		b = append(b, ']')
+	})
+	b = append(b, '}')
+
+	b = append(b, `,"body":`...)
+	if body := ctx.PostBody(); len(body) > 0 && json.Valid(body) {
+		b = append(b, bytes.TrimSpace(body)...)
+	} else {
+		b = appendJSONString(b, body)
+	}
+	b = append(b, `,"time_ns":`...)
+	b = strconv.AppendInt(b, time.Now().UnixNano(), 10)
+	b = append(b, "}\n"...)
+
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Response.SwapBody(b)
+}
+
+// appendJSONString appends s to dst as a quoted JSON string. Invalid
+// UTF-8 is replaced with U+FFFD, as encoding/json does.
+func appendJSONString(dst, s []byte) []byte {
+	const hexDigits = "0123456789abcdef"
+	dst = append(dst, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch {
+			case c == '"' || c == '\\':
+				dst = append(dst, '\\', c)
+			case c == '\n':
+				dst = append(dst, '\\', 'n')
+			case c == '\r':
+				dst = append(dst, '\\', 'r')
+			case c == '\t':
+				dst = append(dst, '\\', 't')
+			case c < ' ':
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			default:
+				dst = append(dst, c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, "\uFFFD"...)
+		} else {
+			dst = append(dst, s[i:i+size]...)
+		}
+		i += size
+	}
+	return append(dst, '"')
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestEcho(t *testing.T) {
+	c := testClient(t, serveEcho)
+
+	for _, tc := range []struct {
+		name, query, body string
+		want              map[string]any
+	}{
+		{
+			name:  "JSON body",
+			query: "?a=1&b=2&b=3",
+			body:  `{"x": 1, "y": [true, null]}`,
+			want: map[string]any{
+				"query": map[string]any{"a": "1", "b": []any{"2", "3"}},
+				"body":  map[string]any{"x": 1.0, "y": []any{true, nil}},
+			},
+		},
+		{
+			name: "text body",
+			body: "not \"json\"\n\xff",
+			want: map[string]any{
+				"query": map[string]any{},
+				"body":  "not \"json\"\n�",
+			},
+		},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI("http://test" + echoPath + tc.query)
+		req.SetBodyString(tc.body)
+		resp := &fasthttp.Response{}
+		err := c.Do(req, resp)
+		fasthttp.ReleaseRequest(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		if got := string(resp.Header.ContentType()); got != "application/json" {
+			t.Errorf("%s: Content-Type %q", tc.name, got)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(resp.Body(), &got); err != nil {
+			t.Fatalf("%s: %v in %q", tc.name, err, resp.Body())
+		}
+		if ns, ok := got["time_ns"].(float64); !ok || ns <= 0 {
+			t.Errorf("%s: time_ns = %v", tc.name, got["time_ns"])
+		}
+		delete(got, "time_ns")
+		tc.want["method"] = fasthttp.MethodPost
+		tc.want["path"] = echoPath
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: echoed %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// embeddedPublic is the public directory compiled into the binary, served
+// instead of the on-disk copy when -embed is set.
+//
+//go:embed public
+var embeddedPublic embed.FS
+
+// embedFiles serves static assets from an fs.FS such as embeddedPublic.
+type embedFiles struct {
+	fsys    fs.FS
+	handler fasthttp.RequestHandler
+}
+
+// newEmbedFiles returns an embedFiles serving the tree rooted at fsys.
+func newEmbedFiles(fsys fs.FS) *embedFiles {
+	return &embedFiles{
+		fsys: fsys,
+		// Range and caching are left to staticServer, as with fileHandler
+		handler: (&fasthttp.FS{FS: fsys, SkipCache: true}).NewRequestHandler(),
+	}
+}
+
+func (e *embedFiles) resolve(reqPath string) (string, error) {
+	// Mirror resolvePath: leading slashes are dropped, but any ".." that
+	// climbs above the root is rejected rather than silently clamped
+	name := path.Clean(strings.TrimLeft(reqPath, "/"))
+	if !fs.ValidPath(name) {
+		return "", errOutsideRoot
+	}
+
+	info, err := fs.Stat(e.fsys, name)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return name, nil
+	}
+
+	indexName := path.Join(name, indexFile)
+	if info, err := fs.Stat(e.fsys, indexName); err != nil || info.IsDir() {
+		return name, errNoIndex
+	}
+	return indexName, nil
+}
+
+func (e *embedFiles) stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(e.fsys, name)
+}
+
+func (e *embedFiles) open(name string) (fs.File, error) {
+	return e.fsys.Open(name)
+}
+
+func (e *embedFiles) readDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(e.fsys, name)
+}
+
+func (e *embedFiles) tree() fs.FS {
+	return e.fsys
+}
+
+func (e *embedFiles) id() string {
+	return "embedded"
+}
+
+func (e *embedFiles) serve(ctx *fasthttp.RequestCtx, name string) {
+	ctx.Request.SetRequestURI(escapePath("/" + name))
+	e.handler(ctx)
+}
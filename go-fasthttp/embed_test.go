@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func embeddedRoot(t *testing.T) *embedFiles {
+	t.Helper()
+	sub, err := fs.Sub(embeddedPublic, "public")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newEmbedFiles(sub)
+}
+
+func TestEmbeddedFile(t *testing.T) {
+	want, err := os.ReadFile("public/sample.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An empty directory, so only the embedded copy can answer
+	urls, _ := startServer(t, t.TempDir(), "-embed")
+
+	resp := get(t, urls[0]+"/sample.txt")
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != string(want) {
+		t.Errorf("status %d, body %q; want 200 and %q", resp.StatusCode(), resp.Body(), want)
+	}
+	if resp := get(t, urls[0]+"/missing.txt"); resp.StatusCode() != fasthttp.StatusNotFound {
+		t.Errorf("missing file: status %d, want 404", resp.StatusCode())
+	}
+}
+
+func TestEmbeddedResolve(t *testing.T) {
+	e := embeddedRoot(t)
+	for _, tc := range []struct {
+		reqPath, want string
+		err           error
+	}{
+		{reqPath: "/sample.txt", want: "sample.txt"},
+		{reqPath: "//sample.txt", want: "sample.txt"},
+		{reqPath: "/x/../sample.txt", want: "sample.txt"},
+		{reqPath: "/", want: ".", err: errNoIndex},
+		{reqPath: "/missing.txt", err: fs.ErrNotExist},
+		{reqPath: "/../main.go", err: errOutsideRoot},
+		{reqPath: "/x/../../main.go", err: errOutsideRoot},
+		{reqPath: "../../etc/passwd", err: errOutsideRoot},
+	} {
+		got, err := e.resolve(tc.reqPath)
+		if (tc.err != nil && !errors.Is(err, tc.err)) || (tc.err == nil && err != nil) {
+			t.Errorf("resolve(%q) error %v, want %v", tc.reqPath, err, tc.err)
+			continue
+		}
+		if tc.want != "" && got != tc.want {
+			t.Errorf("resolve(%q) = %q, want %q", tc.reqPath, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// proxiedKey marks responses relayed from a -proxy upstream, whose error
+// bodies are passed through untouched.
+const proxiedKey = "proxied"
+
+// errorPage is a custom body for one error status, read at startup.
+type errorPage struct {
+	body        []byte
+	contentType string
+}
+
+// loadErrorPages parses mappings of the form status=file and reads the
+// files. Only 4xx and 5xx statuses may be mapped.
+func loadErrorPages(specs []string) (map[int]errorPage, error) {
+	pages := make(map[int]errorPage, len(specs))
+	for _, spec := range specs {
+		code, file, ok := strings.Cut(spec, "=")
+		status, err := strconv.Atoi(code)
+		if !ok || err != nil || file == "" {
+			return nil, fmt.Errorf("invalid mapping %q: want status=file", spec)
+		}
+		if status < fasthttp.StatusBadRequest || status > 599 {
+			return nil, fmt.Errorf("invalid mapping %q: status must be 4xx or 5xx", spec)
+		}
+		body, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping %q: %w", spec, err)
+		}
+		pages[status] = errorPage{body: body, contentType: contentType(file)}
+	}
+	return pages, nil
+}
+
+// withErrorPages replaces the body of error responses that have a custom
+// page, keeping their status and other headers such as Allow or
+// Retry-After. Statuses without a page keep the plain-text body.
+func withErrorPages(next fasthttp.RequestHandler, pages map[int]errorPage) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		page, ok := pages[ctx.Response.StatusCode()]
+		if !ok || ctx.UserValue(proxiedKey) != nil {
+			return
+		}
+		ctx.SetContentType(page.contentType)
+		ctx.Response.SetBodyRaw(page.body)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestErrorPages(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"404.html": "<h1>gone</h1>", "405.json": `{"error":"method"}`})
+	pages, err := loadErrorPages([]string{
+		"404=" + filepath.Join(dir, "404.html"),
+		"405=" + filepath.Join(dir, "405.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testClient(t, withErrorPages(func(ctx *fasthttp.RequestCtx) {
+		switch string(ctx.Path()) {
+		case "/missing":
+			notFound(ctx)
+		case "/upstream":
+			ctx.SetUserValue(proxiedKey, true)
+			ctx.Error("upstream says no", fasthttp.StatusNotFound)
+		case "/wrong-method":
+			ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+			ctx.Response.Header.Set(fasthttp.HeaderAllow, "GET, HEAD")
+		case "/teapot":
+			ctx.Error("I'm a teapot", fasthttp.StatusTeapot)
+		default:
+			ctx.SetBodyString("ok")
+		}
+	}, pages))
+
+	for _, tc := range []struct {
+		path, contentType, body string
+		status                  int
+	}{
+		{"/missing", "text/html; charset=utf-8", "<h1>gone</h1>", fasthttp.StatusNotFound},
+		{"/upstream", "text/plain; charset=utf-8", "upstream says no", fasthttp.StatusNotFound},
+		{"/wrong-method", "application/json", `{"error":"method"}`, fasthttp.StatusMethodNotAllowed},
+		// Statuses without a page keep the plain-text body
+		{"/teapot", "text/plain; charset=utf-8", "I'm a teapot", fasthttp.StatusTeapot},
+		{"/", "text/plain; charset=utf-8", "ok", fasthttp.StatusOK},
+	} {
+		resp := fetch(t, c, fasthttp.MethodGet, tc.path)
+		if resp.StatusCode() != tc.status || string(resp.Body()) != tc.body {
+			t.Errorf("GET %s: status %d, body %q; want %d %q", tc.path, resp.StatusCode(), resp.Body(), tc.status, tc.body)
+		}
+		if got := string(resp.Header.ContentType()); got != tc.contentType {
+			t.Errorf("GET %s: Content-Type %q, want %q", tc.path, got, tc.contentType)
+		}
+	}
+	if resp := fetch(t, c, fasthttp.MethodGet, "/wrong-method"); string(resp.Header.Peek(fasthttp.HeaderAllow)) != "GET, HEAD" {
+		t.Errorf("Allow = %q, want it kept", resp.Header.Peek(fasthttp.HeaderAllow))
+	}
+}
+
+func TestLoadErrorPagesInvalid(t *testing.T) {
+	for _, spec := range []string{"404", "abc=x.html", "404=", "302=x.html", "600=x.html", "404=missing.html"} {
+		if _, err := loadErrorPages([]string{spec}); err == nil {
+			t.Errorf("loadErrorPages(%q) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// bytesPrefix serves /bytes/{n}, a body of exactly n filler bytes for
+// bandwidth tests that need no files on disk.
+const bytesPrefix = "/bytes/"
+
+// fillerAlphabet repeats through every filler body, so byte i of a body is
+// always fillerAlphabet[i%64] whatever its length.
+const fillerAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// fillerBlock is the filler pattern repeated to 64KB. Bodies up to its size
+// are sliced from it; larger ones are streamed from it by fillerReader.
+var fillerBlock = []byte(strings.Repeat(fillerAlphabet, 64<<10/len(fillerAlphabet)))
+
+// fillerReader reads n bytes of filler.
+type fillerReader struct {
+	// off is the position in the body, which fixes the pattern offset
+	off, n int
+}
+
+func (r *fillerReader) Read(p []byte) (int, error) {
+	if r.off >= r.n {
+		return 0, io.EOF
+	}
+	p = p[:min(len(p), r.n-r.off)]
+	read := 0
+	for read < len(p) {
+		read += copy(p[read:], fillerBlock[(r.off+read)%len(fillerBlock):])
+	}
+	r.off += read
+	return read, nil
+}
+
+// serveBytes returns a handler for bytesPrefix answering with n bytes of
+// filler for n up to max, and 400 for anything else.
+func serveBytes(max int) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		// ParseUint rejects signs, so only plain digits get through
+		value := strings.TrimPrefix(string(ctx.Path()), bytesPrefix)
+		n, err := strconv.ParseUint(value, 10, 63)
+		if err != nil {
+			ctx.Error("Bad Request: size must be a non-negative integer", fasthttp.StatusBadRequest)
+			return
+		}
+		if n > uint64(max) {
+			ctx.Error("Bad Request: size must be at most "+strconv.Itoa(max), fasthttp.StatusBadRequest)
+			return
+		}
+
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.SetContentType("application/octet-stream")
+		if int(n) <= len(fillerBlock) {
+			ctx.Response.SetBodyRaw(fillerBlock[:n])
+			return
+		}
+		ctx.SetBodyStream(&fillerReader{n: int(n)}, int(n))
+	}
+}
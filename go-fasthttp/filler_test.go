@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestServeBytes(t *testing.T) {
+	const maxSize = 200000
+	c := testClient(t, serveBytes(maxSize))
+
+	// Either side of the in-memory block, and across its wrap when streamed
+	for _, n := range []int{0, 1, 100, len(fillerBlock), len(fillerBlock) + 1, maxSize} {
+		path := bytesPrefix + strconv.Itoa(n)
+		resp := fetch(t, c, fasthttp.MethodGet, path)
+		if resp.StatusCode() != fasthttp.StatusOK || resp.Header.ContentLength() != n || len(resp.Body()) != n {
+			t.Errorf("GET %s: status %d, Content-Length %d, %d bytes", path, resp.StatusCode(), resp.Header.ContentLength(), len(resp.Body()))
+			continue
+		}
+		if got := string(resp.Header.ContentType()); got != "application/octet-stream" {
+			t.Errorf("GET %s: Content-Type %q", path, got)
+		}
+		for i, b := range resp.Body() {
+			if b != fillerAlphabet[i%len(fillerAlphabet)] {
+				t.Errorf("GET %s: byte %d is %q, want %q", path, i, b, fillerAlphabet[i%len(fillerAlphabet)])
+				break
+			}
+		}
+	}
+
+	for value, body := range map[string]string{
+		strconv.Itoa(maxSize + 1): "Bad Request: size must be at most 200000",
+		"99999999999999999999":    "Bad Request: size must be a non-negative integer",
+		"-1":                      "Bad Request: size must be a non-negative integer",
+		"+5":                      "Bad Request: size must be a non-negative integer",
+		"1.5":                     "Bad Request: size must be a non-negative integer",
+		"10abc":                   "Bad Request: size must be a non-negative integer",
+		"":                        "Bad Request: size must be a non-negative integer",
+	} {
+		resp := fetch(t, c, fasthttp.MethodGet, bytesPrefix+value)
+		if resp.StatusCode() != fasthttp.StatusBadRequest || string(resp.Body()) != body {
+			t.Errorf("GET %s%s: status %d, body %q; want 400 %q", bytesPrefix, value, resp.StatusCode(), resp.Body(), body)
+		}
+	}
+}
@@ -2,10 +2,15 @@ module go-fasthttp
 
 go 1.21
 
-require github.com/valyala/fasthttp v1.51.0
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/valyala/fasthttp v1.51.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/andybalholm/brotli v1.0.5 // indirect
 	github.com/klauspost/compress v1.17.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// listenUnix listens on a Unix domain socket at path, replacing any stale
+// socket file left behind by an earlier run, and sets its file mode. It
+// mirrors fasthttp.Server.ListenAndServeUNIX but returns the listener.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot remove stale socket %q: %w", path, err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("cannot chmod %#o for %q: %w", mode, path, err)
+	}
+	return ln, nil
+}
+
+// writeChunk bounds how much data a single write deadline covers.
+const writeChunk = 1 << 20
+
+// writeTimeoutListener wraps accepted connections in writeTimeoutConn.
+//
+// fasthttp's own Server.WriteTimeout is one deadline for the whole response,
+// which would cut off large downloads to slow but healthy clients. Applying
+// the timeout per chunk instead only fails a transfer that stops progressing.
+type writeTimeoutListener struct {
+	net.Listener
+	timeout time.Duration
+}
+
+func (l writeTimeoutListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &writeTimeoutConn{Conn: c, timeout: l.timeout}, nil
+}
+
+// writeTimeoutConn gives every chunk of at most writeChunk bytes written to
+// the connection its own deadline of timeout.
+type writeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *writeTimeoutConn) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > writeChunk {
+			chunk = chunk[:writeChunk]
+		}
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return written, err
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// ReadFrom keeps the underlying connection's sendfile fast path, which
+// fasthttp uses for large files, while still renewing the deadline per chunk.
+func (c *writeTimeoutConn) ReadFrom(r io.Reader) (int64, error) {
+	rf, ok := c.Conn.(io.ReaderFrom)
+	if !ok {
+		// Hide ReadFrom from io.Copy so it falls back to Write
+		return io.Copy(struct{ io.Writer }{c}, r)
+	}
+
+	// sendfile sees through one *io.LimitedReader to the file beneath, but
+	// not two, so each chunk bounds the reader under fasthttp's own limit
+	// rather than wrapping it again, counting that limit down as it goes
+	src, lr := r, (*io.LimitedReader)(nil)
+	if l, ok := r.(*io.LimitedReader); ok {
+		src, lr = l.R, l
+	}
+	// Cleared afterwards, so the last chunk's deadline does not linger
+	defer c.Conn.SetWriteDeadline(time.Time{})
+
+	var total int64
+	for lr == nil || lr.N > 0 {
+		size := int64(writeChunk)
+		if lr != nil && lr.N < size {
+			size = lr.N
+		}
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+			return total, err
+		}
+		n, err := rf.ReadFrom(&io.LimitedReader{R: src, N: size})
+		total += n
+		if lr != nil {
+			lr.N -= n
+		}
+		if err != nil || n < size {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// countingListener wraps accepted connections in countingConn, so handlers
+// can learn how many bytes their responses took on the wire.
+type countingListener struct {
+	net.Listener
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: c}, nil
+}
+
+// countingConn counts the bytes written to the connection.
+type countingConn struct {
+	net.Conn
+	written atomic.Int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+// ReadFrom passes through to the underlying connection's ReadFrom, if it
+// has one, so file bodies keep sendfile.
+func (c *countingConn) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{c.Conn}, r)
+	}
+	c.written.Add(n)
+	return n, err
+}
+
+// counted returns the countingConn under c, or nil if c is not counted.
+// TLS connections are counted beneath the encryption.
+func counted(c net.Conn) *countingConn {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	cc, _ := c.(*countingConn)
+	return cc
+}
+
+// netConn returns the connection beneath c's TLS and the wrappers above.
+func netConn(c net.Conn) net.Conn {
+	for {
+		switch w := c.(type) {
+		case *tls.Conn:
+			c = w.NetConn()
+		case *countingConn:
+			c = w.Conn
+		case *writeTimeoutConn:
+			c = w.Conn
+		default:
+			return c
+		}
+	}
+}
+
+// writeHook runs once fasthttp has written the response: fasthttp closes
+// user values that are io.Closers after the write, before the next request.
+type writeHook func()
+
+func (h writeHook) Close() error {
+	h()
+	return nil
+}
+
+// afterWrite calls fn with the number of bytes, headers included, that the
+// response to ctx took on the connection, once it has been written. The
+// key must be unique to the caller. On a connection that is not counted fn
+// runs straight away with the body size, or -1 for a stream of unknown
+// length.
+//
+// fasthttp only flushes pipelined responses after the last one in a batch,
+// so those bytes are credited to the last; totals for a connection are
+// still exact.
+func afterWrite(ctx *fasthttp.RequestCtx, key string, fn func(written int)) {
+	cc := counted(ctx.Conn())
+	if cc == nil {
+		fn(responseSize(&ctx.Response))
+		return
+	}
+	start := cc.written.Load()
+	ctx.SetUserValue(key, writeHook(func() { fn(int(cc.written.Load() - start)) }))
+}
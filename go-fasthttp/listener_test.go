@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readFromConn is a net.Conn that records the readers handed to its
+// ReadFrom and the write deadlines set on it.
+type readFromConn struct {
+	net.Conn
+	readers   []io.Reader
+	deadlines []time.Time
+}
+
+func (c *readFromConn) ReadFrom(r io.Reader) (int64, error) {
+	lr, _ := r.(*io.LimitedReader)
+	c.readers = append(c.readers, &io.LimitedReader{R: lr.R, N: lr.N})
+	return io.Copy(io.Discard, r)
+}
+
+func (c *readFromConn) SetWriteDeadline(t time.Time) error {
+	c.deadlines = append(c.deadlines, t)
+	return nil
+}
+
+func TestWriteTimeoutConnReadFrom(t *testing.T) {
+	size := int64(writeChunk*2 + writeChunk/2)
+	name := filepath.Join(t.TempDir(), "big.bin")
+	if err := os.WriteFile(name, make([]byte, size+100), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	raw := &readFromConn{}
+	c := &writeTimeoutConn{Conn: raw, timeout: time.Second}
+	// fasthttp hands file bodies over wrapped in a LimitedReader like this
+	lr := &io.LimitedReader{R: f, N: size}
+	n, err := c.ReadFrom(lr)
+	if err != nil || n != size {
+		t.Fatalf("ReadFrom = %d, %v; want %d", n, err, size)
+	}
+	if lr.N != 0 {
+		t.Errorf("limit left at %d, want 0", lr.N)
+	}
+
+	// Each chunk must reach the connection as a single LimitedReader over
+	// the file itself, the only shape sendfile accepts
+	want := []int64{writeChunk, writeChunk, writeChunk / 2}
+	if len(raw.readers) != len(want) {
+		t.Fatalf("%d chunks, want %d", len(raw.readers), len(want))
+	}
+	for i, r := range raw.readers {
+		chunk := r.(*io.LimitedReader)
+		if chunk.R != f || chunk.N != want[i] {
+			t.Errorf("chunk %d: %T of %d bytes, want the *os.File bounded to %d", i, chunk.R, chunk.N, want[i])
+		}
+	}
+
+	// A fresh deadline per chunk, then none once the body is written
+	if len(raw.deadlines) != len(want)+1 {
+		t.Fatalf("%d deadlines set, want %d", len(raw.deadlines), len(want)+1)
+	}
+	for i, d := range raw.deadlines[:len(want)] {
+		if d.IsZero() {
+			t.Errorf("chunk %d written without a deadline", i)
+		}
+	}
+	if last := raw.deadlines[len(want)]; !last.IsZero() {
+		t.Errorf("deadline left at %s after the body", last)
+	}
+}
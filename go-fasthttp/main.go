@@ -1,44 +1,648 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"math"
+	"net"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/valyala/fasthttp"
 )
 
+// shutdownTimeout bounds how long in-flight connections may drain on exit.
+const shutdownTimeout = 10 * time.Second
+
+// noDeadline stands in for an unlimited read timeout where fasthttp treats
+// zero as "keep the current deadline".
+const noDeadline = 100 * 365 * 24 * time.Hour
+
 func main() {
-	port := 8080
+	configPath := flag.String("config", "", "YAML or JSON file of settings; flags given on the command line override it")
+	port := flag.Int("port", 8080, "port to listen on (overrides $PORT)")
+	addr := flag.String("addr", "0.0.0.0", "address to bind to")
+	logFormat := flag.String("log-format", logFormatText, "access log format: text or json")
+	useEmbed := flag.Bool("embed", false, "serve the public directory compiled into the binary instead of reading it from disk")
+	maxAge := flag.Int("max-age", 3600, "Cache-Control max-age for static files, in seconds")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file; serves HTTPS when set with -tls-cert")
+	httpsPort := flag.Int("https-port", 0, "serve HTTPS on this port while keeping plain HTTP on -port")
+	maxBody := flag.Int("max-body", 4<<20, "maximum request body size in bytes; larger requests get 413")
+	concurrency := flag.Int("concurrency", fasthttp.DefaultConcurrency, "maximum concurrent connections accepted by the server")
+	maxInflight := flag.Int("max-inflight", 0, "maximum requests handled at once before answering 503 (0 means unlimited)")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum time to read a request, headers and body; only the body with -header-timeout")
+	headerTimeout := flag.Duration("header-timeout", 0, "maximum time to read request headers, from their first byte (0 leaves them to -read-timeout)")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Second, "maximum time for each chunk of a response write to make progress")
+	idleTimeout := flag.Duration("idle-timeout", 60*time.Second, "maximum time to wait for the next request on a keep-alive connection")
+	disableKeepalive := flag.Bool("disable-keepalive", false, "close every connection after one response instead of reusing it")
+	tcpKeepalive := flag.Bool("tcp-keepalive", true, "send TCP keep-alive probes on idle connections to detect dead peers")
+	tcpKeepalivePeriod := flag.Duration("tcp-keepalive-period", 0, "interval between TCP keep-alive probes (0 uses the system default)")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "maximum concurrent connections from one client IP (0 means unlimited)")
+	rate := flag.Float64("rate", 0, "requests per second allowed per client IP before answering 429 (0 means unlimited)")
+	burst := flag.Int("burst", 0, "requests a client may make at once under -rate (default -rate rounded up)")
+	corsOrigins := flag.String("cors-origins", "", "comma separated origins allowed cross-origin access, or * for any (empty disables CORS)")
+	authPrefix := flag.String("auth-prefix", "", "require HTTP Basic credentials for paths starting with this prefix")
+	authUser := flag.String("auth-user", "", "username required under -auth-prefix")
+	authPass := flag.String("auth-pass", "", "password required under -auth-prefix")
+	requireRoot := flag.Bool("require-root", false, "answer /healthz with 503 while a static root is missing or unreadable")
+	preload := flag.Bool("preload", false, "fill -cache with every static file at startup, up to -cache-size")
+	watch := flag.Bool("watch", false, "drop -cache entries as soon as their files change on disk")
+	streamThreshold := flag.Int64("stream-threshold", 1<<20, "stream files larger than this many bytes from disk (0 leaves every file to fasthttp's file handler)")
+	useCache := flag.Bool("cache", false, "hold static files in memory instead of reading them per request")
+	cacheSize := flag.Int64("cache-size", 64<<20, "maximum bytes of file contents held by -cache")
+	var listenAddrs stringList
+	flag.Var(&listenAddrs, "listen", "serve on this host:port instead of -addr and -port (repeatable, all sharing one handler)")
+	unixSocket := flag.String("unix", "", "listen on this Unix domain socket path instead of TCP")
+	unixMode := flag.String("unix-mode", "0660", "file mode of the -unix socket, in octal")
+	rootBody := flag.String("root-body", "Go!", "body of / when public has no index.html; when given, / always answers with it")
+	rootContentType := flag.String("root-content-type", "text/plain; charset=utf-8", "content type of the -root-body response")
+	var rootSpecs stringList
+	flag.Var(&rootSpecs, "root", "serve a directory under a path prefix, as /prefix=dir (repeatable; /=dir replaces public)")
+	dirListing := flag.Bool("dir-listing", false, "list the contents of directories that have no index.html")
+	strictSlash := flag.Bool("strict-slash", false, "redirect directories to a trailing slash and files away from one")
+	spa := flag.Bool("spa", false, "serve index.html for unknown paths requested by browsers (Accept: text/html)")
+	var mimeTypes stringList
+	flag.Var(&mimeTypes, "mime", "content type for a file extension, as .ext=type (repeatable)")
+	allowCIDR := flag.String("allow-cidr", "", "comma separated CIDR ranges allowed to connect (empty allows all)")
+	denyCIDR := flag.String("deny-cidr", "", "comma separated CIDR ranges refused with 403, overriding -allow-cidr")
+	var errorPageSpecs stringList
+	flag.Var(&errorPageSpecs, "error-page", "serve a file as the body of an error status, as 404=./404.html (repeatable)")
+	var proxySpecs stringList
+	flag.Var(&proxySpecs, "proxy", "forward a path prefix to an upstream, as /prefix=http://host:port (repeatable)")
+	var delaySpecs stringList
+	flag.Var(&delaySpecs, "delay", "wait before answering requests under a path prefix, as /slow=250ms (repeatable)")
+	bytesMax := flag.Int("bytes-max", 64<<20, "largest body /bytes/{n} will generate, in bytes (0 disables the route)")
+	enablePprof := flag.Bool("pprof", false, "serve runtime profiles under /debug/pprof/ (do not expose publicly)")
+	compress := flag.Bool("compress", true, "compress responses with br or gzip for clients that accept them")
+	secHeaders := flag.Bool("security-headers", false, "add nosniff, frame and referrer headers to every response, and HSTS over TLS")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated CIDR ranges of proxies whose X-Forwarded-For is believed")
+	bench := flag.Bool("bench", false, "benchmark the server over loopback instead of listening, then exit")
+	benchRequests := flag.Int("bench-requests", 10000, "requests sent by -bench")
+	benchConcurrency := flag.Int("bench-concurrency", 50, "concurrent connections used by -bench")
+	benchPath := flag.String("bench-path", "/sample.txt", "static file requested by -bench alongside /")
+	redirect := flag.Bool("redirect-https", false, "redirect plain HTTP requests to HTTPS; requires -https-port")
+	flag.Parse()
 
-	handler := func(ctx *fasthttp.RequestCtx) {
-		path := string(ctx.Path())
+	// Flag takes precedence over $PORT, then the config file, then the default
+	portOnCommandLine := isFlagSet("port")
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			log.Fatalf("Invalid config %s: %v", *configPath, err)
+		}
+		if err := cfg.apply(); err != nil {
+			log.Fatalf("Invalid config %s: %v", *configPath, err)
+		}
+	}
+	if !portOnCommandLine {
+		if env := os.Getenv("PORT"); env != "" {
+			p, err := strconv.Atoi(env)
+			if err != nil {
+				log.Fatalf("Invalid PORT %q: %v", env, err)
+			}
+			*port = p
+		}
+	}
+	if !validPort(*port) {
+		log.Fatalf("Invalid port %d: must be between 1 and 65535", *port)
+	}
+	if *logFormat != logFormatText && *logFormat != logFormatJSON {
+		log.Fatalf("Invalid log format %q: must be %q or %q", *logFormat, logFormatText, logFormatJSON)
+	}
+	if *maxAge < 0 {
+		log.Fatalf("Invalid max-age %d: must not be negative", *maxAge)
+	}
+	if *maxBody <= 0 {
+		log.Fatalf("Invalid max-body %d: must be positive", *maxBody)
+	}
+	if *concurrency <= 0 {
+		log.Fatalf("Invalid concurrency %d: must be positive", *concurrency)
+	}
+	if *maxInflight < 0 {
+		log.Fatalf("Invalid max-inflight %d: must not be negative", *maxInflight)
+	}
+	if *readTimeout < 0 || *headerTimeout < 0 || *writeTimeout < 0 || *idleTimeout < 0 {
+		log.Fatalf("Invalid timeouts: -read-timeout, -header-timeout, -write-timeout and -idle-timeout must not be negative")
+	}
+	if *tcpKeepalivePeriod < 0 {
+		log.Fatalf("Invalid tcp-keepalive-period %s: must not be negative", *tcpKeepalivePeriod)
+	}
+	if *maxConnsPerIP < 0 {
+		log.Fatalf("Invalid max-conns-per-ip %d: must not be negative", *maxConnsPerIP)
+	}
+	if *bench && (*benchRequests <= 0 || *benchConcurrency <= 0) {
+		log.Fatalf("Invalid bench config: -bench-requests and -bench-concurrency must be positive")
+	}
+	if *bytesMax < 0 {
+		log.Fatalf("Invalid bytes-max %d: must not be negative", *bytesMax)
+	}
+	if *rate < 0 || *burst < 0 {
+		log.Fatalf("Invalid rate limit: -rate and -burst must not be negative")
+	}
+	if *burst > 0 && *rate == 0 {
+		log.Fatalf("Invalid rate limit: -burst requires -rate")
+	}
+	if *rate > 0 && *burst == 0 {
+		*burst = int(math.Ceil(*rate))
+	}
+	if *authPrefix != "" && (*authUser == "" || *authPass == "") {
+		log.Fatalf("Invalid auth config: -auth-prefix requires -auth-user and -auth-pass")
+	}
+	if *streamThreshold < 0 {
+		log.Fatalf("Invalid stream-threshold %d: must not be negative", *streamThreshold)
+	}
+	if *useCache && *cacheSize <= 0 {
+		log.Fatalf("Invalid cache-size %d: must be positive", *cacheSize)
+	}
+	err := registerMIMETypes(mimeTypes)
+	if err != nil {
+		log.Fatalf("Invalid mime: %v", err)
+	}
+	roots, err := parseRoots(rootSpecs)
+	if err != nil {
+		log.Fatalf("Invalid root: %v", err)
+	}
+	proxies, err := parseProxies(proxySpecs)
+	if err != nil {
+		log.Fatalf("Invalid proxy: %v", err)
+	}
+	errorPages, err := loadErrorPages(errorPageSpecs)
+	if err != nil {
+		log.Fatalf("Invalid error-page: %v", err)
+	}
+	delays, err := parseDelays(delaySpecs)
+	if err != nil {
+		log.Fatalf("Invalid delay: %v", err)
+	}
+	var filter ipFilter
+	if filter.allow, err = parseCIDRs(*allowCIDR); err != nil {
+		log.Fatalf("Invalid allow-cidr: %v", err)
+	}
+	if filter.deny, err = parseCIDRs(*denyCIDR); err != nil {
+		log.Fatalf("Invalid deny-cidr: %v", err)
+	}
+	trusted, err := parseCIDRs(*trustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted-proxies: %v", err)
+	}
+	socketMode, err := strconv.ParseUint(*unixMode, 8, 32)
+	if err != nil {
+		log.Fatalf("Invalid unix-mode %q: %v", *unixMode, err)
+	}
+	for _, a := range listenAddrs {
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			log.Fatalf("Invalid listen %q: %v", a, err)
+		}
+	}
+	if len(listenAddrs) > 0 && (*unixSocket != "" || *httpsPort != 0) {
+		log.Fatalf("Invalid listener config: -listen cannot be combined with -unix or -https-port")
+	}
+	if *unixSocket != "" && (*tlsCert != "" || *tlsKey != "") {
+		log.Fatalf("Invalid listener config: -unix cannot be combined with TLS")
+	}
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatalf("Invalid TLS config: -tls-cert and -tls-key must be given together")
+	}
+	useTLS := *tlsCert != ""
+	if *httpsPort != 0 {
+		if !useTLS {
+			log.Fatalf("Invalid TLS config: -https-port requires -tls-cert and -tls-key")
+		}
+		if !validPort(*httpsPort) || *httpsPort == *port {
+			log.Fatalf("Invalid https-port %d: must be between 1 and 65535 and differ from -port", *httpsPort)
+		}
+	}
+	if *redirect && *httpsPort == 0 {
+		log.Fatalf("Invalid TLS config: -redirect-https requires -https-port")
+	}
+
+	// public is served at / unless a -root maps / elsewhere
+	publicDir := "public"
+	var mounts []rootMapping
+	for _, root := range roots {
+		if root.prefix == "" {
+			if *useEmbed {
+				log.Fatalf("Invalid root: -embed cannot be combined with a -root for /")
+			}
+			publicDir = root.dir
+			continue
+		}
+		mounts = append(mounts, root)
+	}
+
+	// One cache is shared by every root so -cache-size bounds them together
+	var cache *fileCache
+	if *useCache {
+		cache = newFileCache(*cacheSize)
+	}
+	cacheControl := "public, max-age=" + strconv.Itoa(*maxAge)
+	var diskRoots []string
+	newStatic := func(prefix, dir string) *staticServer {
+		root, err := staticRoot(dir)
+		if err != nil {
+			log.Fatalf("Error resolving static root %s: %v", dir, err)
+		}
+		diskRoots = append(diskRoots, root)
+		return &staticServer{
+			files:           diskFiles{root: root},
+			prefix:          prefix,
+			cacheControl:    cacheControl,
+			cache:           cache,
+			dirListing:      *dirListing,
+			strictSlash:     *strictSlash,
+			streamThreshold: *streamThreshold,
+		}
+	}
+
+	var static *staticServer
+	if *useEmbed {
+		sub, err := fs.Sub(embeddedPublic, "public")
+		if err != nil {
+			log.Fatalf("Error opening embedded files: %v", err)
+		}
+		static = &staticServer{
+			files:           newEmbedFiles(sub),
+			cacheControl:    cacheControl,
+			cache:           cache,
+			dirListing:      *dirListing,
+			strictSlash:     *strictSlash,
+			streamThreshold: *streamThreshold,
+		}
+	} else {
+		static = newStatic("", publicDir)
+	}
+
+	router := NewRouter()
+	router.NotFound = notFound
 
-		// Serve root route
-		if path == "/" {
-			ctx.SetContentType("text/plain; charset=utf-8")
-			ctx.SetStatusCode(fasthttp.StatusOK)
-			ctx.SetBodyString("Go!")
+	// Serve root route when public has no index.html. An explicit
+	// -root-body is served as-is, skipping the filesystem entirely
+	fixedRoot := isFlagSet("root-body")
+	rootPayload := []byte(*rootBody)
+	router.GET("/", func(ctx *fasthttp.RequestCtx) {
+		if !fixedRoot && static.serve(ctx, "/") {
 			return
 		}
+		ctx.SetContentType(*rootContentType)
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		ctx.Response.SetBodyRaw(rootPayload)
+	})
 
-		// Serve static files from public directory
-		filePath := filepath.Join("public", path)
-		if _, err := os.Stat(filePath); err == nil {
-			fasthttp.ServeFile(ctx, filePath)
+	// Serve static files from public directory, using index.html for directories
+	router.GET("/*", func(ctx *fasthttp.RequestCtx) {
+		if static.serve(ctx, string(ctx.Path())) {
+			return
+		}
+		// Single-page apps route client-side, so page navigations to unknown
+		// paths get the app shell while missing assets still 404
+		if *spa && acceptsHTML(ctx) && static.serve(ctx, "/") {
 			return
 		}
+		notFound(ctx)
+	})
+
+	router.Any(echoPath, serveEcho)
+	if *bytesMax > 0 {
+		router.GET(bytesPrefix+"*", serveBytes(*bytesMax))
+	}
+
+	// Mapped roots and proxied prefixes are longer than /*, so they win over
+	// public; among themselves the longest prefix wins
+	statics := []*staticServer{static}
+	for _, root := range mounts {
+		mounted := newStatic(root.prefix, root.dir)
+		statics = append(statics, mounted)
+		mountStatic(router, mounted)
+	}
+	for _, p := range proxies {
+		p.register(router)
+	}
+	// /status reports whether each prefix can be served
+	statusRoots := make([]rootMapping, 0, len(statics))
+	for _, s := range statics {
+		var dir string
+		if d, ok := s.files.(diskFiles); ok {
+			dir = d.root
+		}
+		statusRoots = append(statusRoots, rootMapping{prefix: s.prefix, dir: dir})
+	}
+
+	// Without the cache every request reads from disk, so there is nothing
+	// to invalidate; embedded files never change
+	if *watch && cache != nil && len(diskRoots) > 0 {
+		if err := watchRoots(cache, diskRoots); err != nil {
+			log.Fatalf("Error watching static roots: %v", err)
+		}
+	}
 
-		// Not found
-		ctx.SetStatusCode(fasthttp.StatusNotFound)
-		ctx.SetBodyString("Not found")
+	// A missing root is not fatal, as it may be mounted later, but say so
+	// rather than leaving every request to 404 without explanation
+	var health func() error
+	for _, root := range diskRoots {
+		if err := checkRoot(root); err != nil {
+			log.Printf("Warning: static root %s is unavailable, its files will 404: %v", root, err)
+		}
+	}
+	// Failing health checks is opt-in, as a server without public/ is a
+	// valid setup whose other routes still answer
+	if *requireRoot && len(diskRoots) > 0 {
+		health = func() error {
+			for _, root := range diskRoots {
+				if err := checkRoot(root); err != nil {
+					return fmt.Errorf("static root %s: %w", root, err)
+				}
+			}
+			return nil
+		}
 	}
 
-	addr := fmt.Sprintf(":%d", port)
-	fmt.Printf("Listening on http://localhost%s\n", addr)
+	// Preload before listening so the first request is already a cache hit
+	if *preload && cache != nil {
+		var files int
+		var size int64
+		for _, st := range statics {
+			n, bytes, err := st.preload()
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Fatalf("Error preloading static files: %v", err)
+			}
+			files += n
+			size += bytes
+		}
+		log.Printf("Preloaded %d files (%d bytes) into the cache", files, size)
+	}
+
+	if *enablePprof {
+		registerPprof(router)
+	}
+	// Routes rather than middleware, so the pages go through the IP filter,
+	// Basic Auth and security headers like any other request
+	m := newMetrics()
+	router.GET(metricsPath, m.serve)
+	router.GET(statusPath, serveStatus(m, statusRoots))
 
-	if err := fasthttp.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Error in ListenAndServe: %v", err)
+	handler := router.Handler()
+	// Innermost, so only requests that reach a route are held, and they
+	// keep their in-flight slot while they wait
+	if len(delays) > 0 {
+		handler = withDelay(handler, delays)
+	}
+	if *authPrefix != "" {
+		handler = withBasicAuth(handler, *authPrefix, *authUser, *authPass)
+	}
+	if *compress {
+		handler = withCompression(handler)
+	}
+
+	// CORS sits outside auth, since browsers send preflights without credentials
+	if *corsOrigins != "" {
+		handler = withCORS(handler, parseCORSOrigins(*corsOrigins))
+	}
+	if *maxInflight > 0 {
+		handler = withInflightLimit(handler, *maxInflight)
+	}
+	// Rate limit ahead of the in-flight slot so shed requests cost nothing
+	if *rate > 0 {
+		handler = withRateLimit(handler, newRateLimiter(*rate, *burst))
+	}
+	// Refuse filtered clients before they take an in-flight slot or a token
+	if len(filter.allow) > 0 || len(filter.deny) > 0 {
+		handler = withIPFilter(handler, filter)
+	}
+	// Recover inside logging and metrics so panics are recorded as 500s
+	handler = withRecover(handler)
+	// Outside recover so panics get the 500 page too
+	if len(errorPages) > 0 {
+		handler = withErrorPages(handler, errorPages)
 	}
+	if *secHeaders {
+		handler = withSecurityHeaders(handler)
+	}
+
+	newServer := func(h fasthttp.RequestHandler) *fasthttp.Server {
+		srv := &fasthttp.Server{
+			Handler:            h,
+			ErrorHandler:       serverError,
+			MaxRequestBodySize: *maxBody,
+			Concurrency:        *concurrency,
+			ReadTimeout:        *readTimeout,
+			IdleTimeout:        *idleTimeout,
+			// WriteTimeout is applied per chunk by writeTimeoutListener
+			DisableKeepalive: *disableKeepalive,
+			MaxConnsPerIP:    *maxConnsPerIP,
+			// fasthttp only applies these to bare *net.TCPConn, so listenTCP
+			// sets them on the listener as well for wrapped connections
+			TCPKeepalive:       *tcpKeepalive,
+			TCPKeepalivePeriod: *tcpKeepalivePeriod,
+		}
+		// fasthttp arms ReadTimeout when a request's first byte arrives, so
+		// it bounds the headers alone; once they are read the deadline is
+		// moved on to give the body its own -read-timeout
+		if *headerTimeout > 0 {
+			srv.ReadTimeout = *headerTimeout
+			bodyTimeout := *readTimeout
+			if bodyTimeout == 0 {
+				bodyTimeout = noDeadline
+			}
+			srv.HeaderReceived = func(*fasthttp.RequestHeader) fasthttp.RequestConfig {
+				return fasthttp.RequestConfig{ReadTimeout: bodyTimeout}
+			}
+		}
+		return srv
+	}
+
+	// Health checks bypass everything; metrics and logging see the final,
+	// compressed response, including requests shed by -max-inflight. The
+	// request ID is assigned before logging so every line carries it
+	logged := withMetrics(withRequestID(withLogging(handler, *logFormat)), m)
+	// The client IP is resolved first, for logging, rate limits and filters
+	if len(trusted) > 0 {
+		logged = withClientIP(logged, trusted)
+	}
+	server := newServer(withHealthz(logged, health))
+
+	// With -redirect-https the plain listener gets its own server that only
+	// redirects; health checks still answer there so probes need no TLS
+	plain := server
+	if *redirect {
+		plain = newServer(withHealthz(redirectHTTPS(*httpsPort), health))
+	}
+	servers := []*fasthttp.Server{server}
+	if plain != server {
+		servers = append(servers, plain)
+	}
+
+	// The benchmark drives the full handler chain except access logging,
+	// whose output would dominate the measurement
+	if *bench {
+		stats, err := runBench(withRequestID(handler), []string{"/", *benchPath}, *benchRequests, *benchConcurrency)
+		if err != nil {
+			log.Fatalf("Error running benchmark: %v", err)
+		}
+		stats.writeTo(os.Stdout)
+		return
+	}
+
+	// Every listener is bound before any starts serving, so a bad address
+	// fails fast without the others having announced themselves
+	type listener struct {
+		srv    *fasthttp.Server
+		ln     net.Listener
+		url    string
+		secure bool
+	}
+	var listeners []listener
+	listenTCP := func(srv *fasthttp.Server, listenAddr string, secure bool) {
+		lc := net.ListenConfig{KeepAlive: *tcpKeepalivePeriod}
+		if !*tcpKeepalive {
+			lc.KeepAlive = -1
+		}
+		ln, err := lc.Listen(context.Background(), "tcp", listenAddr)
+		if err != nil {
+			for _, l := range listeners {
+				l.ln.Close()
+			}
+			log.Fatalf("Error listening on %s: %v", listenAddr, err)
+		}
+		scheme := "http://"
+		if secure {
+			scheme = "https://"
+		}
+		// A :0 port is announced as the one the system picked
+		if _, p, _ := net.SplitHostPort(listenAddr); p == "0" {
+			listenAddr = ln.Addr().String()
+		}
+		listeners = append(listeners, listener{srv, ln, scheme + listenAddr, secure})
+	}
+	hostPort := func(port int) string {
+		return net.JoinHostPort(*addr, strconv.Itoa(port))
+	}
+
+	switch {
+	case *unixSocket != "":
+		ln, err := listenUnix(*unixSocket, os.FileMode(socketMode))
+		if err != nil {
+			log.Fatalf("Error listening on %s: %v", *unixSocket, err)
+		}
+		listeners = append(listeners, listener{server, ln, "unix:" + *unixSocket, false})
+	case len(listenAddrs) > 0:
+		for _, a := range listenAddrs {
+			listenTCP(server, a, useTLS)
+		}
+	case !useTLS:
+		listenTCP(server, hostPort(*port), false)
+	case *httpsPort == 0:
+		listenTCP(server, hostPort(*port), true)
+	default:
+		listenTCP(plain, hostPort(*port), false)
+		listenTCP(server, hostPort(*httpsPort), true)
+	}
+
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		ln := l.ln
+		if *writeTimeout > 0 {
+			ln = writeTimeoutListener{Listener: ln, timeout: *writeTimeout}
+		}
+		// Outermost, so handlers can find it beneath any TLS
+		ln = countingListener{Listener: ln}
+		fmt.Printf("Listening on %s\n", l.url)
+		go func(srv *fasthttp.Server, secure bool) {
+			if secure {
+				serveErr <- srv.ServeTLS(ln, *tlsCert, *tlsKey)
+				return
+			}
+			serveErr <- srv.Serve(ln)
+		}(l.srv, l.secure)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		log.Fatalf("Error in listener: %v", err)
+	case <-sig:
+	}
+
+	// Stop accepting new connections and let active ones finish
+	var open int32
+	for _, srv := range servers {
+		open += srv.GetOpenConnectionsCount()
+	}
+	// fasthttp counts each Serve call as an open connection and discounts
+	// only one of them per server
+	open -= int32(len(listeners) - len(servers))
+	log.Printf("Shutting down, draining %d connections", open)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	err = shutdownAll(ctx, servers)
+	cancel()
+	if *unixSocket != "" {
+		// Closing the listener normally unlinks the socket; this catches the rest
+		if rmErr := os.Remove(*unixSocket); rmErr != nil && !os.IsNotExist(rmErr) {
+			log.Printf("Error removing socket %s: %v", *unixSocket, rmErr)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Error during shutdown: %v", err)
+	}
+}
+
+// shutdownAll shuts the servers down concurrently so they share one drain
+// deadline, returning every error encountered.
+func shutdownAll(ctx context.Context, servers []*fasthttp.Server) error {
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *fasthttp.Server) {
+			defer wg.Done()
+			errs[i] = srv.ShutdownWithContext(ctx)
+		}(i, srv)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// serverError answers requests that fasthttp failed to read. It matches
+// fasthttp's default handling, except that bodies over MaxRequestBodySize
+// get 413 instead of a generic 400.
+func serverError(ctx *fasthttp.RequestCtx, err error) {
+	var smallBuffer *fasthttp.ErrSmallBuffer
+	var netErr *net.OpError
+	switch {
+	case errors.Is(err, fasthttp.ErrBodyTooLarge):
+		ctx.Error("Request body too large", fasthttp.StatusRequestEntityTooLarge)
+	case errors.As(err, &smallBuffer):
+		ctx.Error("Too big request header", fasthttp.StatusRequestHeaderFieldsTooLarge)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		ctx.Error("Request timeout", fasthttp.StatusRequestTimeout)
+	default:
+		ctx.Error("Error when parsing request", fasthttp.StatusBadRequest)
+	}
+}
+
+// acceptsHTML reports whether the request is a browser page navigation.
+func acceptsHTML(ctx *fasthttp.RequestCtx) bool {
+	return bytes.Contains(ctx.Request.Header.Peek(fasthttp.HeaderAccept), []byte("text/html"))
+}
+
+// notFound writes the plain-text 404 response.
+func notFound(ctx *fasthttp.RequestCtx) {
+	ctx.SetStatusCode(fasthttp.StatusNotFound)
+	ctx.SetBodyString("Not found")
+}
+
+// validPort reports whether port is a usable TCP port number.
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
 }
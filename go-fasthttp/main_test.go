@@ -0,0 +1,813 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+// runMainEnv makes the test binary run main instead of the tests, so
+// startServer can exercise the real flag handling in a child process.
+const runMainEnv = "GO_FASTHTTP_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// syncBuffer is a bytes.Buffer safe to read while a child process writes
+// to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog sends the standard logger's output to a buffer until the
+// test ends.
+func captureLog(t *testing.T) *syncBuffer {
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+// testListener serves h on an in-memory listener for the duration of the
+// test, counting bytes written as main does.
+func testListener(t *testing.T, h fasthttp.RequestHandler) *fasthttputil.InmemoryListener {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	srv := &fasthttp.Server{Handler: h, ErrorHandler: serverError}
+	go srv.Serve(countingListener{Listener: ln})
+	t.Cleanup(func() { srv.Shutdown() })
+	return ln
+}
+
+// testClient serves h on an in-memory listener for the duration of the
+// test and returns a client connected to it.
+func testClient(t *testing.T, h fasthttp.RequestHandler) *fasthttp.Client {
+	t.Helper()
+	ln := testListener(t, h)
+	return &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) { return ln.Dial() },
+	}
+}
+
+// fetch sends a request for path with the given header name and value
+// pairs, failing the test if it cannot be sent.
+func fetch(t *testing.T, c *fasthttp.Client, method, path string, header ...string) *fasthttp.Response {
+	t.Helper()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://test" + path)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp := &fasthttp.Response{}
+	if err := c.Do(req, resp); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp
+}
+
+// okHandler answers every request with ok.
+func okHandler(ctx *fasthttp.RequestCtx) {
+	ctx.SetBodyString("ok")
+}
+
+// writeFiles creates the named files, relative to dir, with the given
+// contents.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testStatic returns a staticServer for a new directory holding files.
+func testStatic(t *testing.T, files map[string]string) (*staticServer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, files)
+	root, err := staticRoot(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &staticServer{files: diskFiles{root: root}, cacheControl: "public, max-age=3600"}, root
+}
+
+// staticHandler serves s at /, answering 404 for paths it has no file for.
+func staticHandler(s *staticServer) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if !s.serve(ctx, string(ctx.Path())) {
+			notFound(ctx)
+		}
+	}
+}
+
+// serverCommand returns a command running main with args in dir.
+func serverCommand(dir string, args ...string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	return cmd, nil
+}
+
+// freePort returns a loopback port that nothing is listening on.
+func freePort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+}
+
+// startServer runs main with args in dir until the test ends, returning
+// the URL of every listener it announces and its log output. Unless args
+// pick an address, it listens on a free loopback port.
+func startServer(t *testing.T, dir string, args ...string) ([]string, *syncBuffer) {
+	t.Helper()
+	// Listeners come from -listen and -unix, or else from -addr and -port,
+	// with -https-port adding a second one
+	listeners, picked, https := 0, false, false
+	for _, arg := range args {
+		switch arg {
+		case "-listen", "-unix":
+			listeners++
+		case "-addr", "-port":
+			picked = true
+		case "-https-port":
+			https = true
+		}
+	}
+	switch {
+	case listeners > 0:
+	case picked && https:
+		listeners = 2
+	case picked:
+		listeners = 1
+	default:
+		args = append(args, "-listen", "127.0.0.1:0")
+		listeners = 1
+	}
+
+	cmd, err := serverCommand(dir, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := &syncBuffer{}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Signal(syscall.SIGTERM)
+		cmd.Wait()
+	})
+
+	urls := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if url, ok := strings.CutPrefix(scanner.Text(), "Listening on "); ok {
+				urls <- url
+			}
+		}
+		close(urls)
+	}()
+	var found []string
+	timeout := time.After(10 * time.Second)
+	for len(found) < listeners {
+		select {
+		case url, ok := <-urls:
+			if !ok {
+				t.Fatalf("server exited before listening: %s", stderr)
+			}
+			found = append(found, url)
+		case <-timeout:
+			t.Fatalf("server did not start listening: %s", stderr)
+		}
+	}
+
+	for _, url := range found {
+		network, addr := "unix", strings.TrimPrefix(url, "unix:")
+		if scheme, host, ok := strings.Cut(url, "://"); ok && scheme != "unix" {
+			network, addr = "tcp", host
+		}
+		waitAccepting(t, network, addr, stderr)
+	}
+	return found, stderr
+}
+
+// waitAccepting waits for a server logging to stderr to accept connections
+// on addr, which it may announce before serving.
+func waitAccepting(t *testing.T, network, addr string, stderr *syncBuffer) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not accept connections on %s: %s", addr, stderr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// get fetches url from a server started by startServer.
+func get(t *testing.T, url string, header ...string) *fasthttp.Response {
+	t.Helper()
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI(url)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp := &fasthttp.Response{}
+	if err := fasthttp.DoTimeout(req, resp, 5*time.Second); err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return resp
+}
+
+// waitFor waits for buf to contain a match for re, failing the test if it
+// does not within a few seconds.
+func waitFor(t *testing.T, buf *syncBuffer, re *regexp.Regexp) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if m := re.FindStringSubmatch(buf.String()); m != nil {
+			return m
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no match for %s in %q", re, buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestListenAddress(t *testing.T) {
+	t.Run("flags", func(t *testing.T) {
+		port := freePort(t)
+		urls, _ := startServer(t, t.TempDir(), "-addr", "127.0.0.1", "-port", port)
+		if want := "http://127.0.0.1:" + port; urls[0] != want {
+			t.Errorf("listening on %s, want %s", urls[0], want)
+		}
+		if resp := get(t, urls[0]+"/"); string(resp.Body()) != "Go!" {
+			t.Errorf("body %q, want Go!", resp.Body())
+		}
+	})
+
+	t.Run("PORT", func(t *testing.T) {
+		port := freePort(t)
+		t.Setenv("PORT", port)
+		urls, _ := startServer(t, t.TempDir(), "-addr", "127.0.0.1")
+		if want := "http://127.0.0.1:" + port; urls[0] != want {
+			t.Errorf("listening on %s, want %s", urls[0], want)
+		}
+	})
+
+	t.Run("flag over PORT", func(t *testing.T) {
+		port := freePort(t)
+		t.Setenv("PORT", "1")
+		urls, _ := startServer(t, t.TempDir(), "-addr", "127.0.0.1", "-port", port)
+		if want := "http://127.0.0.1:" + port; urls[0] != want {
+			t.Errorf("listening on %s, want %s", urls[0], want)
+		}
+	})
+}
+
+func TestInvalidPort(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		args []string
+		want string
+	}{
+		{args: []string{"-port", "0"}, want: "Invalid port 0: must be between 1 and 65535"},
+		{args: []string{"-port", "65536"}, want: "Invalid port 65536: must be between 1 and 65535"},
+		{env: "http", want: `Invalid PORT "http"`},
+	} {
+		t.Setenv("PORT", tc.env)
+		cmd, err := serverCommand(t.TempDir(), tc.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := cmd.CombinedOutput()
+		if err == nil {
+			t.Errorf("%v with PORT=%q: server started", tc.args, tc.env)
+		}
+		if !strings.Contains(string(out), tc.want) {
+			t.Errorf("%v with PORT=%q: output %q, want %q", tc.args, tc.env, out, tc.want)
+		}
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	for _, sig := range []os.Signal{os.Interrupt, syscall.SIGTERM} {
+		port := freePort(t)
+		cmd, err := serverCommand(t.TempDir(), "-addr", "127.0.0.1", "-port", port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stderr := &syncBuffer{}
+		cmd.Stderr = stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		waitAccepting(t, "tcp", "127.0.0.1:"+port, stderr)
+
+		cmd.Process.Signal(sig)
+		done := make(chan error, 1)
+		go func() { done <- cmd.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("%v: exited with %v: %s", sig, err, stderr)
+			}
+		case <-time.After(5 * time.Second):
+			cmd.Process.Kill()
+			t.Fatalf("%v: server still running", sig)
+		}
+		if !strings.Contains(stderr.String(), "Shutting down, draining ") {
+			t.Errorf("%v: no shutdown message in %q", sig, stderr)
+		}
+	}
+}
+
+func TestHealthzWithoutPublic(t *testing.T) {
+	// An empty directory, so there is no public to serve
+	urls, stderr := startServer(t, t.TempDir())
+	resp := get(t, urls[0]+"/healthz")
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "ok" {
+		t.Errorf("status %d, body %q; want 200 ok", resp.StatusCode(), resp.Body())
+	}
+	if got := string(resp.Header.ContentType()); got != "text/plain" {
+		t.Errorf("Content-Type %q, want text/plain", got)
+	}
+
+	// A logged request after the probe shows the probe was skipped
+	get(t, urls[0]+"/missing")
+	waitFor(t, stderr, regexp.MustCompile(`GET /missing 404`))
+	if strings.Contains(stderr.String(), "/healthz") {
+		t.Errorf("probe was logged: %q", stderr)
+	}
+}
+
+// writeCert writes a self-signed certificate for 127.0.0.1 and its key to
+// dir, returning their paths and a pool that trusts the certificate.
+func writeCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestTLS(t *testing.T) {
+	certFile, keyFile, pool := writeCert(t, t.TempDir())
+	c := &fasthttp.Client{TLSConfig: &tls.Config{RootCAs: pool}}
+	fetchURL := func(url string) *fasthttp.Response {
+		t.Helper()
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		req.SetRequestURI(url)
+		resp := &fasthttp.Response{}
+		if err := c.DoTimeout(req, resp, 5*time.Second); err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		return resp
+	}
+
+	t.Run("https only", func(t *testing.T) {
+		urls, _ := startServer(t, t.TempDir(), "-tls-cert", certFile, "-tls-key", keyFile)
+		if !strings.HasPrefix(urls[0], "https://") {
+			t.Fatalf("listening on %s, want https", urls[0])
+		}
+		if resp := fetchURL(urls[0] + "/"); string(resp.Body()) != "Go!" {
+			t.Errorf("body %q, want Go!", resp.Body())
+		}
+	})
+
+	t.Run("http and https", func(t *testing.T) {
+		urls, _ := startServer(t, t.TempDir(), "-tls-cert", certFile, "-tls-key", keyFile,
+			"-addr", "127.0.0.1", "-port", freePort(t), "-https-port", freePort(t))
+		var schemes []string
+		for _, url := range urls {
+			scheme, _, _ := strings.Cut(url, "://")
+			schemes = append(schemes, scheme)
+			if resp := fetchURL(url + "/"); string(resp.Body()) != "Go!" {
+				t.Errorf("GET %s/: body %q, want Go!", url, resp.Body())
+			}
+		}
+		if strings.Join(schemes, ",") != "http,https" && strings.Join(schemes, ",") != "https,http" {
+			t.Errorf("listening on %v, want one http and one https address", urls)
+		}
+	})
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	certFile, keyFile, _ := writeCert(t, t.TempDir())
+	_, otherKey, _ := writeCert(t, t.TempDir())
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"cert without key", []string{"-tls-cert", certFile}, "-tls-cert and -tls-key must be given together"},
+		{"key without cert", []string{"-tls-key", keyFile}, "-tls-cert and -tls-key must be given together"},
+		{"https-port without TLS", []string{"-https-port", "8443"}, "-https-port requires -tls-cert and -tls-key"},
+		{"https-port same as port", []string{"-tls-cert", certFile, "-tls-key", keyFile, "-port", "8443", "-https-port", "8443"}, "Invalid https-port 8443"},
+		{"mismatched key", []string{"-tls-cert", certFile, "-tls-key", otherKey, "-addr", "127.0.0.1", "-port", freePort(t)}, "private key does not match public key"},
+	} {
+		cmd, err := serverCommand(t.TempDir(), tc.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan []byte, 1)
+		go func() {
+			out, _ := cmd.CombinedOutput()
+			done <- out
+		}()
+		select {
+		case out := <-done:
+			if cmd.ProcessState.Success() {
+				t.Errorf("%s: server exited cleanly", tc.name)
+			}
+			if !strings.Contains(string(out), tc.want) {
+				t.Errorf("%s: output %q, want %q", tc.name, out, tc.want)
+			}
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			t.Errorf("%s: server kept running", tc.name)
+		}
+	}
+}
+
+func TestBodyTooLarge(t *testing.T) {
+	urls, _ := startServer(t, t.TempDir(), "-max-body", "100")
+	// No route takes POST yet, so a body within the limit reaches the
+	// router and gets 405; one over it is rejected while being read
+	for _, tc := range []struct {
+		size   int
+		status int
+		body   string
+	}{
+		{50, fasthttp.StatusMethodNotAllowed, "Method Not Allowed"},
+		{1000, fasthttp.StatusRequestEntityTooLarge, "Request body too large"},
+	} {
+		req := fasthttp.AcquireRequest()
+		req.Header.SetMethod(fasthttp.MethodPost)
+		req.SetRequestURI(urls[0] + "/")
+		req.SetBodyString(strings.Repeat("x", tc.size))
+		resp := &fasthttp.Response{}
+		err := fasthttp.DoTimeout(req, resp, 5*time.Second)
+		fasthttp.ReleaseRequest(req)
+		if err != nil {
+			t.Fatalf("POST of %d bytes: %v", tc.size, err)
+		}
+		if resp.StatusCode() != tc.status || string(resp.Body()) != tc.body {
+			t.Errorf("POST of %d bytes: %d %q, want %d %q", tc.size, resp.StatusCode(), resp.Body(), tc.status, tc.body)
+		}
+	}
+}
+
+// dialServer opens a raw connection to a server started by startServer.
+func dialServer(t *testing.T, url string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestReadTimeout(t *testing.T) {
+	urls, _ := startServer(t, t.TempDir(), "-read-timeout", "300ms")
+	conn := dialServer(t, urls[0])
+
+	start := time.Now()
+	// Start a request and never finish it
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("connection still open after %s: %v", elapsed, err)
+	}
+	if elapsed < 300*time.Millisecond {
+		t.Errorf("closed after %s, before the timeout", elapsed)
+	}
+	if !strings.HasPrefix(string(reply), "HTTP/1.1 408 ") {
+		t.Errorf("reply %q, want a 408", reply)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "server.sock")
+	urls, _ := startServer(t, dir, "-unix", socket, "-unix-mode", "0600")
+	if urls[0] != "unix:"+socket {
+		t.Errorf("listening on %s, want unix:%s", urls[0], socket)
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket file: %v, %v; want mode 0600", info, err)
+	}
+
+	c := &fasthttp.Client{
+		Dial: func(string) (net.Conn, error) { return net.Dial("unix", socket) },
+	}
+	resp := fetch(t, c, fasthttp.MethodGet, "/")
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "Go!" {
+		t.Errorf("status %d, body %q; want 200 Go!", resp.StatusCode(), resp.Body())
+	}
+}
+
+func TestSPAFallback(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"public/index.html": "<div id=app></div>",
+		"public/app.js":     "start()",
+	})
+	urls, _ := startServer(t, dir, "-spa")
+	const html = "text/html,application/xhtml+xml,*/*;q=0.8"
+
+	for _, tc := range []struct {
+		path, accept string
+		status       int
+		body         string
+	}{
+		{"/dashboard/settings", html, fasthttp.StatusOK, "<div id=app></div>"},
+		{"/app.js", html, fasthttp.StatusOK, "start()"},
+		{"/app.js", "*/*", fasthttp.StatusOK, "start()"},
+		{"/missing.js", "*/*", fasthttp.StatusNotFound, "Not found"},
+		{"/api/users", "application/json", fasthttp.StatusNotFound, "Not found"},
+	} {
+		resp := get(t, urls[0]+tc.path, fasthttp.HeaderAccept, tc.accept)
+		if resp.StatusCode() != tc.status || string(resp.Body()) != tc.body {
+			t.Errorf("GET %s (Accept: %s): status %d, body %q; want %d %q",
+				tc.path, tc.accept, resp.StatusCode(), resp.Body(), tc.status, tc.body)
+		}
+	}
+}
+
+func TestDisableKeepalive(t *testing.T) {
+	urls, _ := startServer(t, t.TempDir(), "-disable-keepalive")
+	conn := dialServer(t, urls[0])
+
+	// Ask for two responses on the one connection
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test\r\n\r\nGET / HTTP/1.1\r\nHost: test\r\n\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("connection not closed: %v", err)
+	}
+	if n := strings.Count(string(reply), "HTTP/1.1 200 "); n != 1 {
+		t.Errorf("got %d responses before the close, want 1: %q", n, reply)
+	}
+	if !strings.Contains(string(reply), "\r\nConnection: close\r\n") {
+		t.Errorf("response lacks Connection: close: %q", reply)
+	}
+
+	// The client's view of the same thing
+	if resp := get(t, urls[0]+"/"); !resp.ConnectionClose() {
+		t.Error("fasthttp client sees a reusable connection")
+	}
+}
+
+func TestMissingRootWarns(t *testing.T) {
+	urls, stderr := startServer(t, t.TempDir())
+	waitFor(t, stderr, regexp.MustCompile(`public is unavailable, its files will 404`))
+
+	// Without -require-root the warning is all; health checks still pass
+	if resp := get(t, urls[0]+"/healthz"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("missing root without -require-root: status %d, body %q; want 200", resp.StatusCode(), resp.Body())
+	}
+}
+
+func TestRequireRoot(t *testing.T) {
+	dir := t.TempDir()
+	urls, stderr := startServer(t, dir, "-require-root")
+	waitFor(t, stderr, regexp.MustCompile(`public is unavailable, its files will 404`))
+
+	resp := get(t, urls[0]+"/healthz")
+	if resp.StatusCode() != fasthttp.StatusServiceUnavailable || !strings.HasPrefix(string(resp.Body()), "unavailable: static root ") {
+		t.Errorf("missing root: status %d, body %q; want 503 naming the root", resp.StatusCode(), resp.Body())
+	}
+
+	// Mounting the root later brings the server up
+	if err := os.Mkdir(filepath.Join(dir, "public"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if resp := get(t, urls[0]+"/healthz"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("root created: status %d, body %q; want 200", resp.StatusCode(), resp.Body())
+	}
+}
+
+func TestRootBody(t *testing.T) {
+	withIndex := t.TempDir()
+	writeFiles(t, withIndex, map[string]string{"public/index.html": "<p>index</p>"})
+
+	for _, tc := range []struct {
+		name              string
+		dir               string
+		args              []string
+		contentType, body string
+	}{
+		{"default", t.TempDir(), nil, "text/plain; charset=utf-8", "Go!"},
+		{"index wins over the default", withIndex, nil, "text/html; charset=utf-8", "<p>index</p>"},
+		{"custom body", t.TempDir(), []string{"-root-body", `{"ok":true}`, "-root-content-type", "application/json"}, "application/json", `{"ok":true}`},
+		{"custom body wins over index", withIndex, []string{"-root-body", "fixed"}, "text/plain; charset=utf-8", "fixed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			urls, _ := startServer(t, tc.dir, tc.args...)
+			resp := get(t, urls[0]+"/")
+			if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != tc.body {
+				t.Errorf("status %d, body %q; want 200 %q", resp.StatusCode(), resp.Body(), tc.body)
+			}
+			if got := string(resp.Header.ContentType()); got != tc.contentType {
+				t.Errorf("Content-Type %q, want %q", got, tc.contentType)
+			}
+		})
+	}
+}
+
+// trickle writes s to conn a byte at a time, every interval, until it is
+// written or conn fails.
+func trickle(conn net.Conn, s string, interval time.Duration) {
+	for i := 0; i < len(s); i++ {
+		if _, err := conn.Write([]byte{s[i]}); err != nil {
+			return
+		}
+		time.Sleep(interval)
+	}
+}
+
+func TestHeaderTimeout(t *testing.T) {
+	urls, _ := startServer(t, t.TempDir(), "-header-timeout", "300ms", "-read-timeout", "10s")
+
+	t.Run("slow headers", func(t *testing.T) {
+		conn := dialServer(t, urls[0])
+		start := time.Now()
+		go trickle(conn, "GET / HTTP/1.1\r\nHost: test\r\nX-Padding: "+strings.Repeat("x", 200)+"\r\n\r\n", 20*time.Millisecond)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// Whatever the server says, the connection must close
+		_, err := io.ReadAll(conn)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("connection still open after %s: %v", elapsed, err)
+		}
+		if elapsed < 300*time.Millisecond || elapsed > 3*time.Second {
+			t.Errorf("closed after %s, want soon after the 300ms header timeout", elapsed)
+		}
+	})
+
+	t.Run("slow body", func(t *testing.T) {
+		// The body gets the full -read-timeout once the headers are in
+		conn := dialServer(t, urls[0])
+		body := strings.Repeat("b", 30)
+		fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: test\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", echoPath, len(body))
+		trickle(conn, body, 20*time.Millisecond)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, err := io.ReadAll(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(reply), "HTTP/1.1 200 ") || !strings.Contains(string(reply), body) {
+			t.Errorf("reply %q, want the echoed body", reply)
+		}
+	})
+}
+
+func TestMultipleListeners(t *testing.T) {
+	urls, _ := startServer(t, t.TempDir(), "-listen", "127.0.0.1:0", "-listen", "127.0.0.1:0")
+	if len(urls) != 2 || urls[0] == urls[1] {
+		t.Fatalf("listening on %v, want two addresses", urls)
+	}
+	for _, url := range urls {
+		resp := get(t, url+"/")
+		if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "Go!" {
+			t.Errorf("GET %s/: status %d, body %q; want 200 Go!", url, resp.StatusCode(), resp.Body())
+		}
+	}
+
+	// An address that is taken stops startup before anything serves
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	cmd, err := serverCommand(t.TempDir(), "-listen", "127.0.0.1:0", "-listen", taken.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	done := make(chan error, 1)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("server started with a taken address")
+		}
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("server kept running with a taken address")
+	}
+	if strings.Contains(stdout.String(), "Listening on") {
+		t.Errorf("server announced a listener before failing: %q", stdout.String())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// durationBounds are the upper bounds, in seconds, of the request duration
+// histogram buckets. They skew small since most benchmark requests finish
+// well under a millisecond.
+var durationBounds = [...]float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005,
+	0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5,
+}
+
+// metrics holds request counters updated lock-free on the hot path.
+type metrics struct {
+	requests atomic.Uint64
+	// statuses is indexed by status code; codes outside 0-599 are dropped
+	statuses [600]atomic.Uint64
+	// buckets are non-cumulative; the extra last bucket counts requests
+	// slower than the largest bound
+	buckets  [len(durationBounds) + 1]atomic.Uint64
+	sumNanos atomic.Uint64
+	// bytes totals the bytes responses took on the wire, headers included
+	bytes atomic.Uint64
+	// start is when the server started, for the uptime on /status
+	start time.Time
+}
+
+// newMetrics returns empty counters started now.
+func newMetrics() *metrics {
+	return &metrics{start: time.Now()}
+}
+
+// observe records one completed request whose response took size bytes,
+// or -1 when the size is unknown.
+func (m *metrics) observe(status, size int, elapsed time.Duration) {
+	m.requests.Add(1)
+	if size > 0 {
+		m.bytes.Add(uint64(size))
+	}
+	if status >= 0 && status < len(m.statuses) {
+		m.statuses[status].Add(1)
+	}
+
+	seconds := elapsed.Seconds()
+	i := 0
+	for i < len(durationBounds) && seconds > durationBounds[i] {
+		i++
+	}
+	m.buckets[i].Add(1)
+	m.sumNanos.Add(uint64(elapsed))
+}
+
+// writeTo renders m in the Prometheus text exposition format.
+func (m *metrics) writeTo(w io.Writer) {
+	fmt.Fprintln(w, "# HELP http_requests_total Total number of HTTP requests served.")
+	fmt.Fprintln(w, "# TYPE http_requests_total counter")
+	fmt.Fprintf(w, "http_requests_total %d\n", m.requests.Load())
+
+	fmt.Fprintln(w, "# HELP http_responses_total Number of HTTP responses by status code.")
+	fmt.Fprintln(w, "# TYPE http_responses_total counter")
+	for code := range m.statuses {
+		if n := m.statuses[code].Load(); n > 0 {
+			fmt.Fprintf(w, "http_responses_total{code=\"%d\"} %d\n", code, n)
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP http_request_duration_seconds HTTP request latency.")
+	fmt.Fprintln(w, "# TYPE http_request_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range durationBounds {
+		cumulative += m.buckets[i].Load()
+		fmt.Fprintf(w, "http_request_duration_seconds_bucket{le=\"%s\"} %d\n",
+			strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	// Derive the count from the buckets so +Inf and _count always agree
+	cumulative += m.buckets[len(durationBounds)].Load()
+	fmt.Fprintf(w, "http_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", cumulative)
+	fmt.Fprintf(w, "http_request_duration_seconds_sum %s\n",
+		strconv.FormatFloat(time.Duration(m.sumNanos.Load()).Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "http_request_duration_seconds_count %d\n", cumulative)
+}
+
+// metricsKey holds the hook that records a request once it is written.
+const metricsKey = "metrics"
+
+// metricsPath serves the counters in the Prometheus text format.
+const metricsPath = "/metrics"
+
+// serve writes the counters of m as the /metrics page.
+func (m *metrics) serve(ctx *fasthttp.RequestCtx) {
+	ctx.SetContentType("text/plain; version=0.0.4; charset=utf-8")
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	m.writeTo(ctx)
+}
+
+// withMetrics records every request in m. The page itself is a route, so
+// it sits behind the IP filter and Basic Auth; scrapes of it and of
+// /status, and -pprof requests, are not counted.
+func withMetrics(next fasthttp.RequestHandler, m *metrics) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if path := string(ctx.Path()); path == metricsPath || path == statusPath {
+			next(ctx)
+			return
+		}
+
+		// Profiles run for seconds on purpose and would skew the histogram
+		if bytes.HasPrefix(ctx.Path(), []byte(pprofPrefix)) {
+			next(ctx)
+			return
+		}
+
+		start := time.Now()
+		next(ctx)
+		status, elapsed := ctx.Response.StatusCode(), time.Since(start)
+		afterWrite(ctx, metricsKey, func(size int) { m.observe(status, size, elapsed) })
+	}
+}
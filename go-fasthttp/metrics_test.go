@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMetricsConcurrent(t *testing.T) {
+	const workers, perWorker = 8, 200
+	m := newMetrics()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	// Scrape throughout, as Prometheus would while traffic is served
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				m.writeTo(io.Discard)
+			}
+		}
+	}()
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			status := fasthttp.StatusOK
+			if w%2 == 1 {
+				status = fasthttp.StatusNotFound
+			}
+			for i := 0; i < perWorker; i++ {
+				m.observe(status, 10, time.Duration(i)*time.Millisecond)
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+
+	var out bytes.Buffer
+	m.writeTo(&out)
+	for _, want := range []string{
+		"http_requests_total 1600\n",
+		`http_responses_total{code="200"} 800` + "\n",
+		`http_responses_total{code="404"} 800` + "\n",
+		`http_request_duration_seconds_bucket{le="+Inf"} 1600` + "\n",
+		"http_request_duration_seconds_count 1600\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, out.String())
+		}
+	}
+	if got := m.bytes.Load(); got != workers*perWorker*10 {
+		t.Errorf("bytes = %d, want %d", got, workers*perWorker*10)
+	}
+}
+
+func TestMetricsHandlerConcurrent(t *testing.T) {
+	m := newMetrics()
+	r := NewRouter()
+	r.GET("/", okHandler)
+	r.GET(metricsPath, m.serve)
+	c := testClient(t, withMetrics(r.Handler(), m))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				// Not fetch: its t.Fatal must only be called from the test goroutine
+				if _, _, err := c.Get(nil, "http://test/"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Each request is recorded once its response is written, which can be
+	// just after the client has read it
+	deadline := time.Now().Add(5 * time.Second)
+	for m.requests.Load() < 400 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	body := string(fetch(t, c, fasthttp.MethodGet, "/metrics").Body())
+	if !strings.Contains(body, "http_requests_total 400\n") {
+		t.Errorf("metrics after 400 requests:\n%s", body)
+	}
+	if !strings.HasPrefix(body, "# HELP http_requests_total ") {
+		t.Errorf("metrics do not start with the requests counter:\n%s", body)
+	}
+}
+
+func TestMetricsBehindIPFilter(t *testing.T) {
+	urls, _ := startServer(t, t.TempDir(), "-deny-cidr", "127.0.0.1")
+	for _, path := range []string{"/", metricsPath} {
+		if resp := get(t, urls[0]+path); resp.StatusCode() != fasthttp.StatusForbidden {
+			t.Errorf("denied client: GET %s got %d, want 403", path, resp.StatusCode())
+		}
+	}
+	if resp := get(t, urls[0]+"/healthz"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("denied client: GET /healthz got %d, want 200", resp.StatusCode())
+	}
+}
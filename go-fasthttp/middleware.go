@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Access log formats accepted by -log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// accessEntry is a single JSON access log line.
+type accessEntry struct {
+	Time       string  `json:"time"`
+	ClientIP   string  `json:"client_ip"`
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Status     int     `json:"status"`
+	Bytes      int     `json:"bytes"`
+	DurationMS float64 `json:"duration_ms"`
+	RequestID  string  `json:"request_id,omitempty"`
+}
+
+// withHealthz answers /healthz ahead of next, so load balancer probes skip
+// logging, compression and the filesystem entirely. When check is non-nil
+// it runs on every probe, and an error it returns gets a 503.
+func withHealthz(next fasthttp.RequestHandler, check func() error) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/healthz" {
+			ctx.SetContentType("text/plain")
+			if check != nil {
+				if err := check(); err != nil {
+					ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+					ctx.SetBodyString("unavailable: " + err.Error())
+					return
+				}
+			}
+			ctx.SetStatusCode(fasthttp.StatusOK)
+			ctx.SetBodyString("ok")
+			return
+		}
+		next(ctx)
+	}
+}
+
+// withRecover turns a panic in next into a 500 response, logging the stack
+// trace, so one bad request doesn't tear down the connection.
+func withRecover(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("panic serving %s %s: %v\n%s", ctx.Method(), ctx.Path(), r, debug.Stack())
+				ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+			}
+		}()
+		next(ctx)
+	}
+}
+
+// withInflightLimit lets at most limit requests run next at once. Requests
+// beyond the limit are turned away immediately with 503 rather than queued,
+// so an overloaded benchmark shows up as errors instead of latency.
+func withInflightLimit(next fasthttp.RequestHandler, limit int) fasthttp.RequestHandler {
+	sem := make(chan struct{}, limit)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			ctx.Error("Service Unavailable", fasthttp.StatusServiceUnavailable)
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, "1")
+			return
+		}
+		defer func() { <-sem }()
+		next(ctx)
+	}
+}
+
+// accessLogKey holds the hook that writes a request's access log line.
+const accessLogKey = "accessLog"
+
+// withLogging writes one access log line per request in the given format,
+// once the response has been written so it can give the bytes sent.
+func withLogging(next fasthttp.RequestHandler, format string) fasthttp.RequestHandler {
+	jsonLog := log.New(os.Stderr, "", 0)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		start := time.Now()
+		// Copy these up front: serveFile rewrites the request URI
+		method := string(ctx.Method())
+		path := string(ctx.Path())
+
+		next(ctx)
+
+		elapsed := time.Since(start)
+		status := ctx.Response.StatusCode()
+		ip := clientIP(ctx)
+		id := requestID(ctx)
+
+		afterWrite(ctx, accessLogKey, func(size int) {
+			if format == logFormatJSON {
+				line, err := json.Marshal(accessEntry{
+					Time:       start.UTC().Format(time.RFC3339Nano),
+					ClientIP:   ip.String(),
+					Method:     method,
+					Path:       path,
+					Status:     status,
+					Bytes:      size,
+					DurationMS: float64(elapsed) / float64(time.Millisecond),
+					RequestID:  id,
+				})
+				if err == nil {
+					jsonLog.Println(string(line))
+				}
+				return
+			}
+			if id != "" {
+				log.Printf("%s %s %s %d %dB %s %s", ip, method, path, status, size, elapsed, id)
+				return
+			}
+			log.Printf("%s %s %s %d %dB %s", ip, method, path, status, size, elapsed)
+		})
+	}
+}
+
+// compressMinSize is the smallest body worth compressing; below it the
+// encoding overhead outweighs the savings.
+const compressMinSize = 1024
+
+// incompressibleTypes lists content type prefixes whose payloads are
+// already compressed, so compressing them again only burns CPU. Generic
+// binary is included too, so /bytes bodies go out at their stated size.
+var incompressibleTypes = [][]byte{
+	[]byte("image/"),
+	[]byte("video/"),
+	[]byte("audio/"),
+	[]byte("application/zip"),
+	[]byte("application/gzip"),
+	[]byte("application/x-gzip"),
+	[]byte("application/octet-stream"),
+}
+
+// compressibleTypes lists the content type prefixes fasthttp's compressor
+// accepts; responses of other types are always sent as-is.
+var compressibleTypes = [][]byte{
+	[]byte("text/"),
+	[]byte("application/"),
+	[]byte("image/svg+xml"),
+	[]byte("font/"),
+	[]byte("multipart/"),
+}
+
+// withCompression compresses responses for clients that accept br or gzip,
+// preferring br, and skips small and already-compressed bodies.
+func withCompression(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	// Wrapping a no-op lets fasthttp compress the response already in ctx,
+	// which keeps file streams streamed rather than buffered. fasthttp picks
+	// br over gzip itself when the client accepts both.
+	compressResponse := fasthttp.CompressHandlerBrotliLevel(func(*fasthttp.RequestCtx) {},
+		fasthttp.CompressBrotliDefaultCompression, fasthttp.CompressDefaultCompression)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		next(ctx)
+
+		resp := &ctx.Response
+		encoding := acceptedEncoding(&ctx.Request.Header)
+		if resp.StatusCode() == fasthttp.StatusNotModified {
+			// A client revalidating a compressed copy sent the weak tag it
+			// was given; answer with that tag rather than the identity one
+			etag := resp.Header.Peek(fasthttp.HeaderETag)
+			if encoding != "" && len(etag) > 0 && bytes.Contains(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), []byte(weakETag(etag))) {
+				resp.Header.Set(fasthttp.HeaderETag, weakETag(etag))
+			}
+			return
+		}
+
+		size := responseSize(resp)
+		// A HEAD for a file carries only the length its body would have
+		headerOnly := ctx.IsHead() && size == 0
+		if headerOnly {
+			size = resp.Header.ContentLength()
+		}
+		if !shouldCompress(resp, size) {
+			return
+		}
+		// The body depends on Accept-Encoding even for clients that get it
+		// as-is, so shared caches must keep the variants apart
+		addVary(&resp.Header, fasthttp.HeaderAcceptEncoding)
+
+		// Checked above so clients offering only deflate get the body as-is
+		switch {
+		case encoding == "":
+			return
+		case headerOnly:
+			// Announce the encoding a GET would get. Its compressed length is
+			// only known once the file is read, so say nothing about it, as
+			// RFC 9110 allows for HEAD; a chunked HEAD would have clients wait
+			// for a trailer that never comes
+			resp.Header.Set(fasthttp.HeaderContentEncoding, encoding)
+			resp.Header.SetContentLength(-1)
+			resp.Header.Del(fasthttp.HeaderTransferEncoding)
+		default:
+			compressResponse(ctx)
+		}
+		// The encoded bytes differ from the file's, so its strong tag no
+		// longer names them; a weak one still revalidates against it
+		if etag := resp.Header.Peek(fasthttp.HeaderETag); len(etag) > 0 && len(resp.Header.ContentEncoding()) > 0 {
+			resp.Header.Set(fasthttp.HeaderETag, weakETag(etag))
+		}
+	}
+}
+
+// weakETag returns etag marked weak, as RFC 9110 requires of a tag sent
+// with a transformed representation.
+func weakETag(etag []byte) string {
+	if bytes.HasPrefix(etag, []byte("W/")) {
+		return string(etag)
+	}
+	return "W/" + string(etag)
+}
+
+// acceptedEncoding returns the encoding fasthttp's compressor uses for a
+// request with header h, or "" if it accepts neither br nor gzip.
+func acceptedEncoding(h *fasthttp.RequestHeader) string {
+	switch {
+	case h.HasAcceptEncoding("br"):
+		return "br"
+	case h.HasAcceptEncoding("gzip"):
+		return "gzip"
+	}
+	return ""
+}
+
+// shouldCompress reports whether resp, with a body of size bytes, is large
+// enough and of a content type that benefits from compression.
+func shouldCompress(resp *fasthttp.Response, size int) bool {
+	// Compressing a partial body would break its Content-Range
+	if resp.StatusCode() == fasthttp.StatusPartialContent {
+		return false
+	}
+	// Already encoded, as proxied responses may be
+	if len(resp.Header.ContentEncoding()) > 0 {
+		return false
+	}
+	// Streams of unknown length are assumed to be large
+	if size >= 0 && size < compressMinSize {
+		return false
+	}
+
+	contentType := resp.Header.ContentType()
+	if bytes.HasPrefix(contentType, []byte("image/svg+xml")) {
+		return true
+	}
+	for _, prefix := range incompressibleTypes {
+		if bytes.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	for _, prefix := range compressibleTypes {
+		if bytes.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary adds value to the Vary header of h unless it is already listed.
+func addVary(h *fasthttp.ResponseHeader, value string) {
+	vary := h.Peek(fasthttp.HeaderVary)
+	switch {
+	case len(vary) == 0:
+		h.Set(fasthttp.HeaderVary, value)
+	case !bytes.Contains(vary, []byte(value)):
+		h.Set(fasthttp.HeaderVary, string(vary)+", "+value)
+	}
+}
+
+// responseSize returns the length of the response body, or -1 for a
+// stream of unknown length. Streams are never read, since calling Body()
+// on one would drain it into memory.
+func responseSize(resp *fasthttp.Response) int {
+	if resp.IsBodyStream() {
+		return resp.Header.ContentLength()
+	}
+	return len(resp.Body())
+}
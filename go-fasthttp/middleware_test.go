@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestCompressionEncodings(t *testing.T) {
+	big := strings.Repeat("compressible text ", 400)
+	bodies := map[string][2]string{
+		"/big.txt":   {"text/plain; charset=utf-8", big},
+		"/small.txt": {"text/plain; charset=utf-8", "tiny"},
+		"/photo.png": {"image/png", big},
+		"/icon.svg":  {"image/svg+xml", big},
+	}
+	c := testClient(t, withCompression(func(ctx *fasthttp.RequestCtx) {
+		b := bodies[string(ctx.Path())]
+		ctx.SetContentType(b[0])
+		ctx.SetBodyString(b[1])
+	}))
+
+	for _, tc := range []struct {
+		path, accept, encoding string
+	}{
+		{"/big.txt", "br, gzip", "br"},
+		{"/big.txt", "gzip, deflate, br", "br"},
+		{"/big.txt", "gzip", "gzip"},
+		{"/big.txt", "gzip, deflate", "gzip"},
+		{"/big.txt", "deflate", ""},
+		{"/big.txt", "", ""},
+		{"/small.txt", "br, gzip", ""},
+		{"/photo.png", "br, gzip", ""},
+		{"/icon.svg", "gzip", "gzip"},
+		{"/icon.svg", "br", "br"},
+	} {
+		resp := fetch(t, c, fasthttp.MethodGet, tc.path, fasthttp.HeaderAcceptEncoding, tc.accept)
+		if got := string(resp.Header.ContentEncoding()); got != tc.encoding {
+			t.Errorf("%s with Accept-Encoding %q: Content-Encoding %q, want %q", tc.path, tc.accept, got, tc.encoding)
+			continue
+		}
+		var body []byte
+		var err error
+		switch tc.encoding {
+		case "br":
+			body, err = resp.BodyUnbrotli()
+		case "gzip":
+			body, err = resp.BodyGunzip()
+		default:
+			body = resp.Body()
+		}
+		if err != nil {
+			t.Errorf("%s with Accept-Encoding %q: %v", tc.path, tc.accept, err)
+		} else if string(body) != bodies[tc.path][1] {
+			t.Errorf("%s with Accept-Encoding %q: body does not match", tc.path, tc.accept)
+		}
+	}
+}
+
+func TestCompressionGzip(t *testing.T) {
+	big := strings.Repeat("compressible text ", 400)
+	s, _ := testStatic(t, map[string]string{"big.txt": big})
+	c := testClient(t, withCompression(staticHandler(s)))
+
+	plain := fetch(t, c, fasthttp.MethodGet, "/big.txt")
+	resp := fetch(t, c, fasthttp.MethodGet, "/big.txt", fasthttp.HeaderAcceptEncoding, "gzip")
+	if got := string(resp.Header.ContentEncoding()); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	body, err := resp.BodyGunzip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != big {
+		t.Errorf("decompressed body is %d bytes, want the %d byte file", len(body), len(big))
+	}
+
+	strong := string(plain.Header.Peek(fasthttp.HeaderETag))
+	etag := string(resp.Header.Peek(fasthttp.HeaderETag))
+	if etag != "W/"+strong {
+		t.Errorf("compressed ETag = %q, want the weak form of %q", etag, strong)
+	}
+
+	resp = fetch(t, c, fasthttp.MethodGet, "/big.txt",
+		fasthttp.HeaderAcceptEncoding, "gzip", fasthttp.HeaderIfNoneMatch, etag)
+	if resp.StatusCode() != fasthttp.StatusNotModified {
+		t.Fatalf("revalidating the weak ETag: status %d, want 304", resp.StatusCode())
+	}
+	if got := string(resp.Header.Peek(fasthttp.HeaderETag)); got != etag {
+		t.Errorf("304 ETag = %q, want %q", got, etag)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"public/a.txt": "hello"})
+
+	t.Run("text", func(t *testing.T) {
+		urls, stderr := startServer(t, dir)
+		get(t, urls[0]+"/a.txt")
+		get(t, urls[0]+"/missing")
+		// Sizes are what went on the wire, headers included
+		waitFor(t, stderr, regexp.MustCompile(`GET /a.txt 200 \d{3,}B \S+ [0-9a-f]{32}\n`))
+		waitFor(t, stderr, regexp.MustCompile(`GET /missing 404 \d{3,}B \S+ [0-9a-f]{32}\n`))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		urls, stderr := startServer(t, dir, "-log-format", "json")
+		get(t, urls[0]+"/a.txt")
+		line := waitFor(t, stderr, regexp.MustCompile(`(?m)^\{.*"path":"/a.txt".*\}$`))[0]
+		var entry accessEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Method != "GET" || entry.Status != fasthttp.StatusOK || entry.Bytes <= len("hello") || entry.DurationMS <= 0 {
+			t.Errorf("logged %+v", entry)
+		}
+		if _, err := time.Parse(time.RFC3339Nano, entry.Time); err != nil {
+			t.Errorf("time %q: %v", entry.Time, err)
+		}
+	})
+}
+
+func TestInvalidLogFormat(t *testing.T) {
+	cmd, err := serverCommand(t.TempDir(), "-log-format", "xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), `Invalid log format "xml"`) {
+		t.Errorf("-log-format xml: %v, output %q", err, out)
+	}
+}
+
+func TestInflightLimit(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	blocking := func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/block" {
+			entered <- struct{}{}
+			<-release
+		}
+		ctx.SetBodyString("ok")
+	}
+	c := testClient(t, withInflightLimit(blocking, 1))
+
+	first := make(chan int)
+	go func() {
+		status, _, err := c.Get(nil, "http://test/block")
+		if err != nil {
+			t.Error(err)
+		}
+		first <- status
+	}()
+	<-entered
+
+	resp := fetch(t, c, fasthttp.MethodGet, "/")
+	if resp.StatusCode() != fasthttp.StatusServiceUnavailable {
+		t.Errorf("request over the limit: status %d, want 503", resp.StatusCode())
+	}
+	if got := string(resp.Header.Peek(fasthttp.HeaderRetryAfter)); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+
+	close(release)
+	if status := <-first; status != fasthttp.StatusOK {
+		t.Errorf("request within the limit: status %d", status)
+	}
+	if resp := fetch(t, c, fasthttp.MethodGet, "/"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("request after the slot freed: status %d", resp.StatusCode())
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logs := captureLog(t)
+	panicky := func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/boom" {
+			panic("boom")
+		}
+		ctx.SetBodyString("ok")
+	}
+	c := testClient(t, withRecover(panicky))
+
+	resp := fetch(t, c, fasthttp.MethodGet, "/boom")
+	if resp.StatusCode() != fasthttp.StatusInternalServerError {
+		t.Errorf("panicking route: status %d, want 500", resp.StatusCode())
+	}
+	if !strings.Contains(logs.String(), "panic serving GET /boom: boom") {
+		t.Errorf("panic not logged: %q", logs)
+	}
+	// The server goes on serving after the panic
+	for i := 0; i < 3; i++ {
+		if resp := fetch(t, c, fasthttp.MethodGet, "/"); resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "ok" {
+			t.Errorf("request after the panic: status %d, body %q", resp.StatusCode(), resp.Body())
+		}
+	}
+}
+
+func TestLoggingBytesWritten(t *testing.T) {
+	big := strings.Repeat("compressible text ", 400)
+	for _, tc := range []struct {
+		name string
+		tune func(*staticServer)
+	}{
+		{name: "in memory"},
+		{name: "compressed stream", tune: func(s *staticServer) { s.streamThreshold = 1024 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := testStatic(t, map[string]string{"big.txt": big})
+			if tc.tune != nil {
+				tc.tune(s)
+			}
+			logs := captureLog(t)
+			ln := testListener(t, withLogging(withCompression(staticHandler(s)), logFormatText))
+
+			conn, err := ln.Dial()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			fmt.Fprint(conn, "GET /big.txt HTTP/1.1\r\nHost: test\r\nAccept-Encoding: gzip\r\nConnection: close\r\n\r\n")
+			wire, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logged, _ := strconv.Atoi(waitFor(t, logs, regexp.MustCompile(` 200 (-?\d+)B `))[1])
+			if logged != len(wire) {
+				t.Errorf("logged %dB, client read %d bytes", logged, len(wire))
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// defaultMIMETypes fixes extensions that system MIME tables often get
+// wrong or lack, which breaks browsers loading them.
+var defaultMIMETypes = map[string]string{
+	".wasm":        "application/wasm",
+	".webmanifest": "application/manifest+json",
+	".mjs":         "text/javascript; charset=utf-8",
+}
+
+// registerMIMETypes installs the default overrides followed by custom ones
+// of the form ".ext=type". Both fasthttp's file handler and contentType
+// look types up through the mime package, so this covers every static
+// response. A type may carry its own charset parameter.
+func registerMIMETypes(custom []string) error {
+	for ext, typ := range defaultMIMETypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			return err
+		}
+	}
+	for _, mapping := range custom {
+		ext, typ, ok := strings.Cut(mapping, "=")
+		if !ok || !strings.HasPrefix(ext, ".") || typ == "" {
+			return fmt.Errorf("invalid mapping %q: want .ext=type", mapping)
+		}
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			return fmt.Errorf("invalid mapping %q: %w", mapping, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestMIMETypes(t *testing.T) {
+	if err := registerMIMETypes([]string{".bench=text/x-bench; charset=utf-8"}); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"app.wasm":         "\x00asm",
+		"site.webmanifest": "{}",
+		"mod.mjs":          "export {}",
+		"run.bench":        "GET /",
+	}
+	want := map[string]string{
+		"/app.wasm":         "application/wasm",
+		"/site.webmanifest": "application/manifest+json",
+		"/mod.mjs":          "text/javascript; charset=utf-8",
+		"/run.bench":        "text/x-bench; charset=utf-8",
+	}
+
+	for _, tc := range []struct {
+		name string
+		tune func(*staticServer)
+	}{
+		{name: "disk"},
+		{name: "cached", tune: func(s *staticServer) { s.cache = newFileCache(1 << 20) }},
+		{name: "streamed", tune: func(s *staticServer) { s.streamThreshold = 1 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := testStatic(t, files)
+			if tc.tune != nil {
+				tc.tune(s)
+			}
+			c := testClient(t, staticHandler(s))
+			for path, typ := range want {
+				if got := string(fetch(t, c, fasthttp.MethodGet, path).Header.ContentType()); got != typ {
+					t.Errorf("%s: Content-Type %q, want %q", path, got, typ)
+				}
+			}
+		})
+	}
+}
+
+func TestMIMETypesInvalid(t *testing.T) {
+	for _, mapping := range []string{"bench=text/plain", ".bench=", "noequals", ".bench"} {
+		if err := registerMIMETypes([]string{mapping}); err == nil {
+			t.Errorf("registerMIMETypes(%q) accepted it", mapping)
+		}
+	}
+}
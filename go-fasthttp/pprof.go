@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http/pprof"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// pprofPrefix is where -pprof mounts the runtime profiles.
+const pprofPrefix = "/debug/pprof/"
+
+// registerPprof routes the net/http/pprof handlers under pprofPrefix. The
+// index also serves the named profiles, such as heap and goroutine.
+func registerPprof(r *Router) {
+	r.GET(pprofPrefix+"*", fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Index))
+	r.GET(pprofPrefix+"cmdline", fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Cmdline))
+	r.GET(pprofPrefix+"profile", fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Profile))
+	r.GET(pprofPrefix+"trace", fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Trace))
+
+	// go tool pprof looks symbols up with POST
+	symbol := fasthttpadaptor.NewFastHTTPHandlerFunc(pprof.Symbol)
+	r.GET(pprofPrefix+"symbol", symbol)
+	r.Handle(fasthttp.MethodPost, pprofPrefix+"symbol", symbol)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestPprof(t *testing.T) {
+	r := NewRouter()
+	registerPprof(r)
+	c := testClient(t, r.Handler())
+
+	resp := fetch(t, c, fasthttp.MethodGet, pprofPrefix+"heap")
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("heap profile: status %d", resp.StatusCode())
+	}
+	// Profiles are gzipped protocol buffers
+	if body := resp.Body(); len(body) < 2 || !bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		t.Errorf("heap profile is %d bytes starting %q, want a gzipped profile", len(body), body[:min(len(body), 8)])
+	}
+
+	index := fetch(t, c, fasthttp.MethodGet, pprofPrefix)
+	if index.StatusCode() != fasthttp.StatusOK || !strings.Contains(string(index.Body()), "heap") {
+		t.Errorf("index: status %d, want 200 listing the heap profile", index.StatusCode())
+	}
+
+	symbol := fetch(t, c, fasthttp.MethodPost, pprofPrefix+"symbol")
+	if symbol.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("POST symbol: status %d, want 200", symbol.StatusCode())
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// proxyTimeout bounds a whole upstream exchange, from dialing to reading
+// the response body.
+const proxyTimeout = 30 * time.Second
+
+// hopHeaders apply to a single connection and must not be forwarded, per
+// RFC 9110 section 7.6.1.
+var hopHeaders = []string{
+	fasthttp.HeaderConnection,
+	"Keep-Alive",
+	fasthttp.HeaderProxyAuthenticate,
+	fasthttp.HeaderProxyAuthorization,
+	fasthttp.HeaderTE,
+	fasthttp.HeaderTrailer,
+	fasthttp.HeaderTransferEncoding,
+	fasthttp.HeaderUpgrade,
+	"Proxy-Connection",
+}
+
+// proxyRoute forwards requests under prefix to one upstream.
+type proxyRoute struct {
+	prefix string
+	scheme string
+	host   string
+	// path is prepended to the request path, so /api/x on an upstream of
+	// http://backend/v1 becomes http://backend/v1/api/x
+	path   string
+	client *fasthttp.HostClient
+}
+
+// parseProxies parses mappings of the form /prefix=http://host[:port][/path].
+func parseProxies(specs []string) ([]*proxyRoute, error) {
+	routes := make([]*proxyRoute, 0, len(specs))
+	for _, spec := range specs {
+		prefix, upstream, ok := strings.Cut(spec, "=")
+		prefix = strings.TrimRight(prefix, "/")
+		if !ok || !strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "*") {
+			return nil, fmt.Errorf("invalid mapping %q: want /prefix=http://host:port", spec)
+		}
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping %q: %w", spec, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid mapping %q: upstream must be an http or https URL", spec)
+		}
+		isTLS := u.Scheme == "https"
+		routes = append(routes, &proxyRoute{
+			prefix: prefix,
+			scheme: u.Scheme,
+			host:   u.Host,
+			path:   strings.TrimRight(u.EscapedPath(), "/"),
+			client: &fasthttp.HostClient{
+				Addr:  fasthttp.AddMissingPort(u.Host, isTLS),
+				IsTLS: isTLS,
+			},
+		})
+	}
+	return routes, nil
+}
+
+// register routes the prefix itself and everything below it to the
+// upstream, for every method.
+func (p *proxyRoute) register(r *Router) {
+	r.Any(p.prefix, p.handle)
+	r.Any(p.prefix+"/*", p.handle)
+}
+
+// handle relays the request to the upstream and its response back to the
+// client, dropping hop-by-hop headers in both directions.
+func (p *proxyRoute) handle(ctx *fasthttp.RequestCtx) {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	ctx.Request.CopyTo(req)
+	stripHopHeaders(&req.Header)
+
+	req.SetRequestURI(p.scheme + "://" + p.host + p.path + string(ctx.URI().RequestURI()))
+	req.Header.SetHostBytes(req.URI().Host())
+	req.Header.Set("X-Forwarded-Host", string(ctx.Host()))
+	req.Header.Set("X-Forwarded-Proto", forwardedProto(ctx))
+	// Earlier proxies may each have added their own field rather than
+	// extending one; Peek would keep only the first
+	forwardedFor := ctx.RemoteIP().String()
+	if prior := ctx.Request.Header.PeekAll("X-Forwarded-For"); len(prior) > 0 {
+		forwardedFor = string(bytes.Join(prior, []byte(", "))) + ", " + forwardedFor
+	}
+	// Set replaces only the first of the fields copied from the client
+	req.Header.Del("X-Forwarded-For")
+	req.Header.Set("X-Forwarded-For", forwardedFor)
+
+	if err := p.client.DoTimeout(req, &ctx.Response, proxyTimeout); err != nil {
+		log.Printf("Error proxying %s to %s: %v", ctx.Path(), p.host, err)
+		if errors.Is(err, fasthttp.ErrTimeout) {
+			ctx.Error("Gateway timeout", fasthttp.StatusGatewayTimeout)
+			return
+		}
+		ctx.Error("Bad gateway", fasthttp.StatusBadGateway)
+		return
+	}
+	stripHopHeaders(&ctx.Response.Header)
+	ctx.SetUserValue(proxiedKey, true)
+}
+
+// forwardedProto returns the scheme the client used to reach us.
+func forwardedProto(ctx *fasthttp.RequestCtx) string {
+	if ctx.IsTLS() {
+		return "https"
+	}
+	return "http"
+}
+
+// hopHeaderDeleter is implemented by both request and response headers.
+type hopHeaderDeleter interface {
+	Peek(key string) []byte
+	Del(key string)
+}
+
+// stripHopHeaders removes the standard hop-by-hop headers along with any
+// the Connection header names.
+func stripHopHeaders(h hopHeaderDeleter) {
+	// Copy the value first, as deleting headers reuses its buffer
+	for _, name := range strings.Split(string(h.Peek(fasthttp.HeaderConnection)), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			h.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
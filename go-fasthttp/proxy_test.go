@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// stubUpstream serves h on a loopback port until the test ends and returns
+// its address.
+func stubUpstream(t *testing.T, h fasthttp.RequestHandler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: h}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown() })
+	return ln.Addr().String()
+}
+
+// proxyFor returns the route parsed from spec.
+func proxyFor(t *testing.T, spec string) *proxyRoute {
+	t.Helper()
+	routes, err := parseProxies([]string{spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return routes[0]
+}
+
+func TestProxy(t *testing.T) {
+	seen := make(chan *fasthttp.Request, 1)
+	upstream := stubUpstream(t, func(ctx *fasthttp.RequestCtx) {
+		req := &fasthttp.Request{}
+		ctx.Request.CopyTo(req)
+		seen <- req
+		ctx.SetStatusCode(fasthttp.StatusCreated)
+		ctx.Response.Header.Set("X-Upstream", "yes")
+		ctx.Response.Header.Set("X-Hop", "1")
+		ctx.Response.Header.Set(fasthttp.HeaderConnection, "X-Hop")
+		ctx.Response.Header.Set("Keep-Alive", "timeout=5")
+		ctx.Response.Header.Set(fasthttp.HeaderProxyAuthenticate, "Basic")
+		ctx.SetBodyString("created")
+	})
+	c := testClient(t, proxyFor(t, "/api=http://"+upstream+"/v1").handle)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.Header.SetMethod(fasthttp.MethodPost)
+	req.SetRequestURI("http://test/api/items?q=1")
+	req.SetBodyString(`{"name":"x"}`)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.Header.Set(fasthttp.HeaderProxyAuthorization, "Basic c2VjcmV0")
+	req.Header.Set(fasthttp.HeaderTE, "trailers")
+	req.Header.Set("Proxy-Connection", "keep-alive")
+	req.Header.Set("X-Kept", "1")
+	resp := &fasthttp.Response{}
+	if err := c.Do(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-seen
+	if string(got.Header.Method()) != fasthttp.MethodPost || string(got.RequestURI()) != "/v1/api/items?q=1" {
+		t.Errorf("upstream got %s %s, want POST /v1/api/items?q=1", got.Header.Method(), got.RequestURI())
+	}
+	if string(got.Body()) != `{"name":"x"}` {
+		t.Errorf("upstream body %q", got.Body())
+	}
+	if host := string(got.Header.Host()); host != upstream {
+		t.Errorf("upstream Host %q, want %q", host, upstream)
+	}
+	for name, want := range map[string]string{
+		"X-Forwarded-Host":                "test",
+		"X-Forwarded-Proto":               "http",
+		"X-Kept":                          "1",
+		fasthttp.HeaderProxyAuthorization: "",
+		fasthttp.HeaderTE:                 "",
+		"Proxy-Connection":                "",
+	} {
+		if v := string(got.Header.Peek(name)); v != want {
+			t.Errorf("upstream %s = %q, want %q", name, v, want)
+		}
+	}
+	if xff := string(got.Header.Peek("X-Forwarded-For")); !strings.HasPrefix(xff, "198.51.100.1, ") {
+		t.Errorf("upstream X-Forwarded-For = %q, want the client appended to the prior value", xff)
+	}
+
+	if resp.StatusCode() != fasthttp.StatusCreated || string(resp.Body()) != "created" {
+		t.Errorf("status %d, body %q; want the upstream's 201", resp.StatusCode(), resp.Body())
+	}
+	for name, want := range map[string]string{
+		"X-Upstream":                     "yes",
+		"X-Hop":                          "",
+		"Keep-Alive":                     "",
+		fasthttp.HeaderProxyAuthenticate: "",
+	} {
+		if v := string(resp.Header.Peek(name)); v != want {
+			t.Errorf("response %s = %q, want %q", name, v, want)
+		}
+	}
+}
+
+func TestProxyForwardedForFields(t *testing.T) {
+	seen := make(chan []string, 1)
+	upstream := stubUpstream(t, func(ctx *fasthttp.RequestCtx) {
+		var fields []string
+		for _, v := range ctx.Request.Header.PeekAll("X-Forwarded-For") {
+			fields = append(fields, string(v))
+		}
+		seen <- fields
+	})
+	c := testClient(t, proxyFor(t, "/api=http://"+upstream).handle)
+
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	req.SetRequestURI("http://test/api/items")
+	req.Header.Add("X-Forwarded-For", "198.51.100.1")
+	req.Header.Add("X-Forwarded-For", "203.0.113.7, 192.0.2.9")
+	if err := c.Do(req, &fasthttp.Response{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every hop is kept, in order, in the single field sent upstream
+	fields := <-seen
+	if len(fields) != 1 || !strings.HasPrefix(fields[0], "198.51.100.1, 203.0.113.7, 192.0.2.9, ") {
+		t.Errorf("upstream X-Forwarded-For fields %q, want one listing every prior hop then the client", fields)
+	}
+}
+
+func TestProxyUnreachable(t *testing.T) {
+	// Grab a free port and close it, so nothing is listening there
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	logs := captureLog(t)
+	c := testClient(t, proxyFor(t, "/api=http://"+addr).handle)
+	resp := fetch(t, c, fasthttp.MethodGet, "/api/x")
+	if resp.StatusCode() != fasthttp.StatusBadGateway {
+		t.Errorf("status %d, want 502", resp.StatusCode())
+	}
+	if !strings.Contains(logs.String(), "Error proxying /api/x") {
+		t.Errorf("failure not logged: %q", logs)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/valyala/fasthttp"
+)
+
+// errUnsatisfiableRange is returned by parseRange when no part of the
+// requested range lies within the file.
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// parseRange parses a Range header against a file of the given size and
+// returns the inclusive byte positions to serve. ok is false when the
+// header should be ignored and the full file served instead: when it is
+// absent, uses units other than bytes, or asks for multiple ranges.
+func parseRange(header []byte, size int64) (start, end int64, ok bool, err error) {
+	if len(header) == 0 || !bytes.HasPrefix(header, []byte("bytes=")) {
+		return 0, 0, false, nil
+	}
+	// Multipart responses aren't supported; the full body is a valid reply
+	if bytes.IndexByte(header, ',') >= 0 {
+		return 0, 0, false, nil
+	}
+	// A zero-length suffix (bytes=-0) or an empty file can't be satisfied,
+	// but fasthttp.ParseByteRange would accept both
+	if size == 0 || bytes.Equal(header, []byte("bytes=-0")) {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+
+	s, e, err := fasthttp.ParseByteRange(header, int(size))
+	if err != nil {
+		return 0, 0, false, errUnsatisfiableRange
+	}
+	return int64(s), int64(e), true, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, tc := range []struct {
+		header     string
+		size       int64
+		start, end int64
+		ok         bool
+		err        error
+	}{
+		{header: "", size: 1000},
+		{header: "bytes=100-", size: 1000, start: 100, end: 999, ok: true},
+		{header: "bytes=-100", size: 1000, start: 900, end: 999, ok: true},
+		{header: "bytes=100-199", size: 1000, start: 100, end: 199, ok: true},
+		{header: "bytes=0-0", size: 1000, start: 0, end: 0, ok: true},
+		{header: "bytes=900-5000", size: 1000, start: 900, end: 999, ok: true},
+		{header: "bytes=-5000", size: 1000, start: 0, end: 999, ok: true},
+		// Ignored, so the whole file is served
+		{header: "items=0-1", size: 1000},
+		{header: "bytes=0-1,5-6", size: 1000},
+		// Invalid or outside the file
+		{header: "bytes=1000-", size: 1000, err: errUnsatisfiableRange},
+		{header: "bytes=-0", size: 1000, err: errUnsatisfiableRange},
+		{header: "bytes=200-100", size: 1000, err: errUnsatisfiableRange},
+		{header: "bytes=abc", size: 1000, err: errUnsatisfiableRange},
+		{header: "bytes=-", size: 1000, err: errUnsatisfiableRange},
+		{header: "bytes=0-", size: 0, err: errUnsatisfiableRange},
+	} {
+		start, end, ok, err := parseRange([]byte(tc.header), tc.size)
+		if start != tc.start || end != tc.end || ok != tc.ok || err != tc.err {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, %v; want %d, %d, %v, %v",
+				tc.header, tc.size, start, end, ok, err, tc.start, tc.end, tc.ok, tc.err)
+		}
+	}
+}
+
+func TestStaticRange(t *testing.T) {
+	body := strings.Repeat("0123456789", 100)
+	for _, tc := range []struct {
+		name string
+		tune func(*staticServer)
+	}{
+		{name: "disk"},
+		{name: "cached", tune: func(s *staticServer) { s.cache = newFileCache(1 << 20) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := testStatic(t, map[string]string{"digits.txt": body})
+			if tc.tune != nil {
+				tc.tune(s)
+			}
+			c := testClient(t, staticHandler(s))
+
+			resp := fetch(t, c, fasthttp.MethodGet, "/digits.txt", fasthttp.HeaderRange, "bytes=-100")
+			if resp.StatusCode() != fasthttp.StatusPartialContent || string(resp.Body()) != body[900:] {
+				t.Errorf("suffix range: status %d, body %q", resp.StatusCode(), resp.Body())
+			}
+			if got := string(resp.Header.Peek(fasthttp.HeaderContentRange)); got != "bytes 900-999/1000" {
+				t.Errorf("Content-Range = %q", got)
+			}
+
+			resp = fetch(t, c, fasthttp.MethodGet, "/digits.txt", fasthttp.HeaderRange, "bytes=100-")
+			if resp.StatusCode() != fasthttp.StatusPartialContent || string(resp.Body()) != body[100:] {
+				t.Errorf("open-ended range: status %d, %d byte body", resp.StatusCode(), len(resp.Body()))
+			}
+
+			resp = fetch(t, c, fasthttp.MethodGet, "/digits.txt", fasthttp.HeaderRange, "bytes=0-1,5-6")
+			if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != body {
+				t.Errorf("multiple ranges: status %d, want the whole file", resp.StatusCode())
+			}
+
+			resp = fetch(t, c, fasthttp.MethodGet, "/digits.txt", fasthttp.HeaderRange, "bytes=2000-")
+			if resp.StatusCode() != fasthttp.StatusRequestedRangeNotSatisfiable {
+				t.Errorf("range past the end: status %d, want 416", resp.StatusCode())
+			}
+			if got := string(resp.Header.Peek(fasthttp.HeaderContentRange)); got != "bytes */1000" {
+				t.Errorf("416 Content-Range = %q", got)
+			}
+		})
+	}
+}
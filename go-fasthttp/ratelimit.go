@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// rateEvictInterval is how often idle buckets are dropped from a
+// rateLimiter.
+const rateEvictInterval = time.Minute
+
+// rateLimiter is a token bucket per client key. Each bucket holds up to
+// burst tokens and refills at rate tokens per second.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket is the state of one client's bucket as of last.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate requests per second with
+// bursts of up to burst, and starts evicting its idle buckets.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+	go func() {
+		for now := range time.Tick(rateEvictInterval) {
+			l.evict(now)
+		}
+	}()
+	return l
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it
+// returns false and how long until a token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst}
+		l.buckets[key] = b
+	} else {
+		elapsed := now.Sub(b.last).Seconds()
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / l.rate
+		return false, time.Duration(wait * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// evict drops buckets that have refilled completely, since a new bucket
+// starts out full anyway. This bounds memory to recently active clients.
+func (l *rateLimiter) evict(now time.Time) {
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// withRateLimit answers 429 to clients that have used up their bucket,
+// with a Retry-After of whole seconds until their next token.
+func withRateLimit(next fasthttp.RequestHandler, l *rateLimiter) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ok, wait := l.allow(clientIP(ctx).String(), time.Now())
+		if !ok {
+			ctx.Error("Too many requests", fasthttp.StatusTooManyRequests)
+			retry := int(math.Ceil(wait.Seconds()))
+			ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(max(retry, 1)))
+			return
+		}
+		next(ctx)
+	}
+}
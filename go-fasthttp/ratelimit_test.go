@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	// Built directly, so no eviction goroutine runs behind the test's clock
+	l := &rateLimiter{rate: 2, burst: 3, buckets: make(map[string]*tokenBucket)}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", t0); !ok {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+	}
+	ok, wait := l.allow("a", t0)
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("request past the burst: %v, wait %s; want refused with 500ms", ok, wait)
+	}
+	if ok, _ := l.allow("b", t0); !ok {
+		t.Error("another client was limited by the first one's bucket")
+	}
+	if ok, _ := l.allow("a", t0.Add(400*time.Millisecond)); ok {
+		t.Error("allowed before a token had refilled")
+	}
+	if ok, _ := l.allow("a", t0.Add(500*time.Millisecond)); !ok {
+		t.Error("refused once a token had refilled")
+	}
+
+	// A long idle spell refills the bucket only up to the burst
+	later := t0.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.allow("a", later); !ok {
+			t.Fatalf("request %d after idling refused", i+1)
+		}
+	}
+	if ok, _ := l.allow("a", later); ok {
+		t.Error("idle time refilled past the burst")
+	}
+}
+
+func TestRateLimiterEvict(t *testing.T) {
+	l := &rateLimiter{rate: 2, burst: 3, buckets: make(map[string]*tokenBucket)}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.allow("idle", t0)
+	l.allow("busy", t0.Add(time.Second))
+
+	// The idle bucket has had its full 1.5s to refill, the busy one has not
+	l.evict(t0.Add(1500 * time.Millisecond))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("refilled bucket kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket still refilling was dropped")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c := testClient(t, withRateLimit(okHandler, newRateLimiter(0.5, 1)))
+
+	if resp := fetch(t, c, fasthttp.MethodGet, "/"); resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("first request: status %d", resp.StatusCode())
+	}
+	resp := fetch(t, c, fasthttp.MethodGet, "/")
+	if resp.StatusCode() != fasthttp.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", resp.StatusCode())
+	}
+	if got := string(resp.Header.Peek(fasthttp.HeaderRetryAfter)); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+}
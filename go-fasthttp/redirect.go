@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// redirectHTTPS returns a handler that permanently redirects every request
+// to the same host, path and query on httpsPort.
+func redirectHTTPS(httpsPort int) fasthttp.RequestHandler {
+	port := strconv.Itoa(httpsPort)
+
+	return func(ctx *fasthttp.RequestCtx) {
+		host := string(ctx.Host())
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		// A bare IPv6 host such as [::1] has no port to split off, but
+		// keeps its brackets, which are added back below
+		host = strings.Trim(host, "[]")
+
+		// Leave the default port implicit, bracketing IPv6 hosts as
+		// JoinHostPort would
+		switch {
+		case httpsPort != 443:
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+
+		ctx.Response.Header.Set(fasthttp.HeaderLocation, "https://"+host+string(ctx.RequestURI()))
+		ctx.SetStatusCode(fasthttp.StatusMovedPermanently)
+	}
+}
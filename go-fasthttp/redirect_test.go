@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRedirectHTTPS(t *testing.T) {
+	for _, tc := range []struct {
+		httpsPort int
+		host, uri string
+		want      string
+	}{
+		{8443, "example.com:8080", "/docs/a.txt?x=1&y=%20z", "https://example.com:8443/docs/a.txt?x=1&y=%20z"},
+		{8443, "example.com", "/", "https://example.com:8443/"},
+		{443, "example.com:8080", "/path?q=1", "https://example.com/path?q=1"},
+		{443, "[::1]:8080", "/path?q=1", "https://[::1]/path?q=1"},
+		{8443, "[::1]:8080", "/a", "https://[::1]:8443/a"},
+		{8443, "[::1]", "/a?b=1", "https://[::1]:8443/a?b=1"},
+		{443, "[::1]", "/a?b=1", "https://[::1]/a?b=1"},
+	} {
+		var ctx fasthttp.RequestCtx
+		ctx.Request.SetRequestURI(tc.uri)
+		ctx.Request.Header.SetHost(tc.host)
+		redirectHTTPS(tc.httpsPort)(&ctx)
+
+		if ctx.Response.StatusCode() != fasthttp.StatusMovedPermanently {
+			t.Errorf("%s%s: status %d, want 301", tc.host, tc.uri, ctx.Response.StatusCode())
+		}
+		if got := string(ctx.Response.Header.Peek(fasthttp.HeaderLocation)); got != tc.want {
+			t.Errorf("%s%s to port %d: Location %q, want %q", tc.host, tc.uri, tc.httpsPort, got, tc.want)
+		}
+	}
+}
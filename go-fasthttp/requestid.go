@@ -0,0 +1,66 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// headerRequestID carries the request ID in both directions.
+	headerRequestID = "X-Request-ID"
+	// requestIDKey is the RequestCtx user value holding the request ID.
+	requestIDKey = "requestID"
+	// maxRequestIDLen caps incoming IDs so clients cannot bloat the logs.
+	maxRequestIDLen = 128
+)
+
+// withRequestID tags every request with an ID, keeping a well-formed
+// incoming X-Request-ID or generating a random one. The ID is stored in
+// the user values, set on the request so proxied upstreams see it, and
+// echoed in the response.
+func withRequestID(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		id := string(ctx.Request.Header.Peek(headerRequestID))
+		if !validRequestID(id) {
+			id = newRequestID()
+			ctx.Request.Header.Set(headerRequestID, id)
+		}
+		ctx.SetUserValue(requestIDKey, id)
+
+		next(ctx)
+
+		// Echoed on the way out so even an error page, whose headers were
+		// rebuilt from scratch, can be matched to its log line
+		ctx.Response.Header.Set(headerRequestID, id)
+	}
+}
+
+// requestID returns the ID withRequestID assigned to ctx, or "" if none.
+func requestID(ctx *fasthttp.RequestCtx) string {
+	id, _ := ctx.UserValue(requestIDKey).(string)
+	return id
+}
+
+// newRequestID returns 16 random bytes in hex.
+func newRequestID() string {
+	var b [16]byte
+	// crypto/rand.Read never fails on supported platforms
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether id is non-empty, not too long and made of
+// visible ASCII, so it is safe to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRequestID(t *testing.T) {
+	// The handler sees the ID both as a user value and on the request, as
+	// the access log and proxied upstreams do
+	echo := func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString(requestID(ctx) + " " + string(ctx.Request.Header.Peek(headerRequestID)))
+	}
+	c := testClient(t, withRequestID(echo))
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	for _, tc := range []struct {
+		name, incoming string
+		kept           bool
+	}{
+		{"incoming kept", "req-42.abc", true},
+		{"longest kept", strings.Repeat("a", maxRequestIDLen), true},
+		{"missing", "", false},
+		{"too long", strings.Repeat("a", maxRequestIDLen+1), false},
+		{"with spaces", "a b", false},
+		{"non-ASCII", "café", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var header []string
+			if tc.incoming != "" {
+				header = []string{headerRequestID, tc.incoming}
+			}
+			resp := fetch(t, c, fasthttp.MethodGet, "/", header...)
+			id := string(resp.Header.Peek(headerRequestID))
+			if tc.kept && id != tc.incoming {
+				t.Errorf("response ID %q, want the incoming %q", id, tc.incoming)
+			}
+			if !tc.kept && !generated.MatchString(id) {
+				t.Errorf("response ID %q, want a generated 32 character hex ID", id)
+			}
+			if body := string(resp.Body()); body != id+" "+id {
+				t.Errorf("handler saw %q, want the ID %q as user value and request header", body, id)
+			}
+		})
+	}
+
+	first := string(fetch(t, c, fasthttp.MethodGet, "/").Header.Peek(headerRequestID))
+	second := string(fetch(t, c, fasthttp.MethodGet, "/").Header.Peek(headerRequestID))
+	if first == second {
+		t.Errorf("two requests both got ID %q", first)
+	}
+}
+
+func TestRequestIDOnErrors(t *testing.T) {
+	failing := func(ctx *fasthttp.RequestCtx) {
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+	}
+	c := testClient(t, withRequestID(failing))
+	resp := fetch(t, c, fasthttp.MethodGet, "/", headerRequestID, "trace-1")
+	if got := string(resp.Header.Peek(headerRequestID)); got != "trace-1" {
+		t.Errorf("error response ID %q, want trace-1", got)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// rootMapping maps a URL prefix onto a directory, from a -root flag.
+type rootMapping struct {
+	// prefix has no trailing slash, so "/" is held as ""
+	prefix string
+	dir    string
+}
+
+// parseRoots parses mappings of the form /prefix=dir.
+func parseRoots(specs []string) ([]rootMapping, error) {
+	roots := make([]rootMapping, 0, len(specs))
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		prefix, dir, ok := strings.Cut(spec, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") || strings.Contains(prefix, "*") || dir == "" {
+			return nil, fmt.Errorf("invalid mapping %q: want /prefix=dir", spec)
+		}
+		prefix = strings.TrimRight(prefix, "/")
+		if seen[prefix] {
+			return nil, fmt.Errorf("invalid mapping %q: prefix mapped twice", spec)
+		}
+		seen[prefix] = true
+		roots = append(roots, rootMapping{prefix: prefix, dir: dir})
+	}
+	return roots, nil
+}
+
+// mountStatic routes the paths below s.prefix to s with the prefix
+// stripped, so /assets/app.js is looked up as /app.js in the mapped
+// directory. The bare prefix redirects to its trailing-slash form so the
+// index page's relative links resolve under the prefix.
+func mountStatic(r *Router, s *staticServer) {
+	r.GET(s.prefix, func(ctx *fasthttp.RequestCtx) {
+		redirectTo(ctx, s.prefix+"/")
+	})
+	r.GET(s.prefix+"/*", func(ctx *fasthttp.RequestCtx) {
+		if !s.serve(ctx, strings.TrimPrefix(string(ctx.Path()), s.prefix)) {
+			notFound(ctx)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestParseRoots(t *testing.T) {
+	roots, err := parseRoots([]string{"/=site", "/assets/=static", "/assets/img=images"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rootMapping{{"", "site"}, {"/assets", "static"}, {"/assets/img", "images"}}
+	if !reflect.DeepEqual(roots, want) {
+		t.Errorf("parseRoots = %+v, want %+v", roots, want)
+	}
+
+	for _, specs := range [][]string{
+		{"/assets"},
+		{"assets=static"},
+		{"/assets/*=static"},
+		{"/assets="},
+		{"/assets=a", "/assets/=b"},
+	} {
+		if _, err := parseRoots(specs); err == nil {
+			t.Errorf("parseRoots(%q) accepted it", specs)
+		}
+	}
+}
+
+func TestOverlappingRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"site/page.txt":         "site",
+		"site/assets/app.txt":   "shadowed by /assets",
+		"static/app.txt":        "static",
+		"static/imgx/note.txt":  "static imgx",
+		"static/img/hidden.txt": "shadowed",
+		"images/logo.txt":       "images",
+	})
+	// The roots share one cache, and preloading fills it with every root's
+	// files, including ones another root shadows
+	for _, tc := range []struct {
+		name  string
+		flags []string
+	}{
+		{name: "disk"},
+		{name: "cache", flags: []string{"-cache"}},
+		{name: "preload", flags: []string{"-cache", "-preload"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"-root", "/=site", "-root", "/assets=static", "-root", "/assets/img=images"}, tc.flags...)
+			urls, _ := startServer(t, dir, args...)
+
+			for _, path := range []string{"/page.txt", "/assets/app.txt", "/assets/img/logo.txt", "/assets/imgx/note.txt"} {
+				want := map[string]string{
+					"/page.txt":             "site",
+					"/assets/app.txt":       "static",
+					"/assets/img/logo.txt":  "images",
+					"/assets/imgx/note.txt": "static imgx",
+				}[path]
+				// Twice, so the second request is served from the cache
+				for i := 0; i < 2; i++ {
+					resp := get(t, urls[0]+path)
+					if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != want {
+						t.Errorf("GET %s: status %d, body %q; want %q", path, resp.StatusCode(), resp.Body(), want)
+					}
+				}
+			}
+			// The longer prefix owns everything below it, even files it lacks
+			if resp := get(t, urls[0]+"/assets/img/hidden.txt"); resp.StatusCode() != fasthttp.StatusNotFound {
+				t.Errorf("GET /assets/img/hidden.txt: status %d, want 404 from the images root", resp.StatusCode())
+			}
+			resp := get(t, urls[0]+"/assets/img")
+			if resp.StatusCode() != fasthttp.StatusMovedPermanently || string(resp.Header.Peek(fasthttp.HeaderLocation)) != urls[0]+"/assets/img/" {
+				t.Errorf("GET /assets/img: status %d, Location %q; want 301 to /assets/img/",
+					resp.StatusCode(), resp.Header.Peek(fasthttp.HeaderLocation))
+			}
+		})
+	}
+}
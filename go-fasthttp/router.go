@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Router dispatches requests by method and path. A pattern is either an
+// exact path or a prefix ending in "/*". Exact patterns take precedence,
+// then the longest matching prefix.
+type Router struct {
+	exact    map[string]map[string]fasthttp.RequestHandler
+	prefixes []prefixRoute
+
+	// NotFound handles requests that match no pattern. It defaults to a
+	// plain 404.
+	NotFound fasthttp.RequestHandler
+}
+
+// prefixRoute holds the handlers registered for one wildcard pattern.
+type prefixRoute struct {
+	prefix  string
+	methods map[string]fasthttp.RequestHandler
+}
+
+// anyMethods are the methods Any registers.
+var anyMethods = []string{
+	fasthttp.MethodGet,
+	fasthttp.MethodHead,
+	fasthttp.MethodPost,
+	fasthttp.MethodPut,
+	fasthttp.MethodPatch,
+	fasthttp.MethodDelete,
+	fasthttp.MethodConnect,
+	fasthttp.MethodOptions,
+	fasthttp.MethodTrace,
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{exact: make(map[string]map[string]fasthttp.RequestHandler)}
+}
+
+// GET registers h for GET requests matching pattern. HEAD requests are
+// routed to h as well, as fasthttp drops the body when writing them.
+func (r *Router) GET(pattern string, h fasthttp.RequestHandler) {
+	r.Handle(fasthttp.MethodGet, pattern, h)
+	r.Handle(fasthttp.MethodHead, pattern, h)
+}
+
+// Any registers h for requests matching pattern with any standard method.
+func (r *Router) Any(pattern string, h fasthttp.RequestHandler) {
+	for _, method := range anyMethods {
+		r.Handle(method, pattern, h)
+	}
+}
+
+// Handle registers h for requests with the given method matching pattern.
+// It panics if pattern is malformed.
+func (r *Router) Handle(method, pattern string, h fasthttp.RequestHandler) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("router: pattern " + pattern + " must begin with /")
+	}
+
+	prefix, wildcard := strings.CutSuffix(pattern, "*")
+	if strings.Contains(prefix, "*") || (wildcard && !strings.HasSuffix(prefix, "/")) {
+		panic("router: pattern " + pattern + " may only end in /*")
+	}
+	if !wildcard {
+		if r.exact[pattern] == nil {
+			r.exact[pattern] = make(map[string]fasthttp.RequestHandler)
+		}
+		r.exact[pattern][method] = h
+		return
+	}
+
+	for _, route := range r.prefixes {
+		if route.prefix == prefix {
+			route.methods[method] = h
+			return
+		}
+	}
+	r.prefixes = append(r.prefixes, prefixRoute{
+		prefix:  prefix,
+		methods: map[string]fasthttp.RequestHandler{method: h},
+	})
+	// Keep the longest prefixes first so the first match is the best one
+	sort.Slice(r.prefixes, func(i, j int) bool {
+		return len(r.prefixes[i].prefix) > len(r.prefixes[j].prefix)
+	})
+}
+
+// Handler returns a fasthttp.RequestHandler dispatching to the registered
+// routes. Requests to a known path with an unregistered method get 405.
+func (r *Router) Handler() fasthttp.RequestHandler {
+	notFound := r.NotFound
+	if notFound == nil {
+		notFound = func(ctx *fasthttp.RequestCtx) {
+			ctx.Error("Not found", fasthttp.StatusNotFound)
+		}
+	}
+
+	return func(ctx *fasthttp.RequestCtx) {
+		methods := r.match(string(ctx.Path()))
+		if methods == nil {
+			notFound(ctx)
+			return
+		}
+		if h, ok := methods[string(ctx.Method())]; ok {
+			h(ctx)
+			return
+		}
+		ctx.Error("Method Not Allowed", fasthttp.StatusMethodNotAllowed)
+		ctx.Response.Header.Set(fasthttp.HeaderAllow, allowedMethods(methods))
+	}
+}
+
+// match returns the handlers registered for the best pattern matching
+// path, or nil when none does.
+func (r *Router) match(path string) map[string]fasthttp.RequestHandler {
+	if methods, ok := r.exact[path]; ok {
+		return methods
+	}
+	for _, route := range r.prefixes {
+		if strings.HasPrefix(path, route.prefix) {
+			return route.methods
+		}
+	}
+	return nil
+}
+
+// allowedMethods formats the methods of a route for an Allow header.
+func allowedMethods(methods map[string]fasthttp.RequestHandler) string {
+	names := make([]string, 0, len(methods))
+	for method := range methods {
+		names = append(names, method)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
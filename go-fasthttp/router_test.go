@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// namedHandler answers with name so tests can tell which route matched.
+func namedHandler(name string) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetBodyString(name)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := NewRouter()
+	r.GET("/", namedHandler("root"))
+	r.GET("/api/*", namedHandler("api"))
+	r.GET("/api/v1/*", namedHandler("v1"))
+	r.GET("/api/v1/users", namedHandler("users"))
+	r.Handle(fasthttp.MethodPost, "/api/v1/users", namedHandler("create"))
+	r.Handle(fasthttp.MethodPost, "/upload/*", namedHandler("upload"))
+	r.NotFound = namedHandler("not found")
+	c := testClient(t, r.Handler())
+
+	for _, tc := range []struct {
+		method, path string
+		status       int
+		body, allow  string
+	}{
+		// Exact patterns match only their own path
+		{"GET", "/", 200, "root", ""},
+		{"GET", "/index.html", 200, "not found", ""},
+		{"GET", "/api/v1/users", 200, "users", ""},
+		{"GET", "/api/v1/users/7", 200, "v1", ""},
+		// "/*" matches the prefix itself and anything below it
+		{"GET", "/api/", 200, "api", ""},
+		{"GET", "/api/v2/x", 200, "api", ""},
+		{"GET", "/api", 200, "not found", ""},
+		{"GET", "/apis", 200, "not found", ""},
+		// The longest overlapping prefix wins
+		{"GET", "/api/v1/", 200, "v1", ""},
+		{"GET", "/api/v1/items/3", 200, "v1", ""},
+		{"HEAD", "/api/v1/x", 200, "", ""},
+		// Methods are matched per pattern
+		{"POST", "/api/v1/users", 200, "create", ""},
+		{"POST", "/upload/a.bin", 200, "upload", ""},
+		{"DELETE", "/api/v1/users", 405, "Method Not Allowed", "GET, HEAD, POST"},
+		{"POST", "/api/x", 405, "Method Not Allowed", "GET, HEAD"},
+		{"GET", "/upload/a.bin", 405, "Method Not Allowed", "POST"},
+	} {
+		resp := fetch(t, c, tc.method, tc.path)
+		if resp.StatusCode() != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, resp.StatusCode(), tc.status)
+		}
+		if string(resp.Body()) != tc.body {
+			t.Errorf("%s %s: body %q, want %q", tc.method, tc.path, resp.Body(), tc.body)
+		}
+		if got := string(resp.Header.Peek(fasthttp.HeaderAllow)); got != tc.allow {
+			t.Errorf("%s %s: Allow %q, want %q", tc.method, tc.path, got, tc.allow)
+		}
+	}
+}
+
+func TestRouterDefaultNotFound(t *testing.T) {
+	r := NewRouter()
+	r.GET("/a", namedHandler("a"))
+	resp := fetch(t, testClient(t, r.Handler()), "GET", "/b")
+	if resp.StatusCode() != fasthttp.StatusNotFound || string(resp.Body()) != "Not found" {
+		t.Errorf("got %d %q, want 404 %q", resp.StatusCode(), resp.Body(), "Not found")
+	}
+}
+
+func TestRouterBadPattern(t *testing.T) {
+	for _, pattern := range []string{"api", "/a*", "/*/b", "/a/**"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Handle(%q) did not panic", pattern)
+				}
+			}()
+			NewRouter().Handle(fasthttp.MethodGet, pattern, namedHandler("x"))
+		}()
+	}
+}
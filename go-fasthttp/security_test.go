@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	s, _ := testStatic(t, map[string]string{"a.txt": "hello"})
+	files := staticHandler(s)
+	c := testClient(t, withSecurityHeaders(func(ctx *fasthttp.RequestCtx) {
+		if string(ctx.Path()) == "/framed" {
+			// As a -proxy upstream allowing same-origin frames would
+			ctx.Response.Header.Set("X-Frame-Options", "SAMEORIGIN")
+		}
+		files(ctx)
+	}))
+
+	for _, path := range []string{"/a.txt", "/missing"} {
+		resp := fetch(t, c, fasthttp.MethodGet, path)
+		for _, header := range securityHeaders {
+			if got := string(resp.Header.Peek(header[0])); got != header[1] {
+				t.Errorf("GET %s: %s = %q, want %q", path, header[0], got, header[1])
+			}
+		}
+		// Plain HTTP, so HSTS would be ignored
+		if got := resp.Header.Peek(fasthttp.HeaderStrictTransportSecurity); len(got) > 0 {
+			t.Errorf("GET %s: Strict-Transport-Security %q sent without TLS", path, got)
+		}
+	}
+	if resp := fetch(t, c, fasthttp.MethodGet, "/a.txt"); string(resp.Body()) != "hello" {
+		t.Errorf("body %q, want the file", resp.Body())
+	}
+
+	resp := fetch(t, c, fasthttp.MethodGet, "/framed")
+	if got := string(resp.Header.Peek("X-Frame-Options")); got != "SAMEORIGIN" {
+		t.Errorf("X-Frame-Options = %q, want the response's own kept", got)
+	}
+}
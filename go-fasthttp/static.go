@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// indexFile is served in place of a directory.
+const indexFile = "index.html"
+
+// staticFiles is a tree of static assets that request paths are served from.
+type staticFiles interface {
+	// resolve maps a request path onto a file name accepted by serve,
+	// mapping directories onto their index file. It returns errOutsideRoot
+	// for paths that escape the tree, and the directory's own name with
+	// errNoIndex for a directory without an index file.
+	resolve(reqPath string) (string, error)
+	// stat describes the named file.
+	stat(name string) (fs.FileInfo, error)
+	// open opens the named file for reading. The result also implements
+	// io.Seeker.
+	open(name string) (fs.File, error)
+	// readDir lists the named directory, sorted by name, leaving out
+	// entries that lead outside the tree.
+	readDir(name string) ([]fs.DirEntry, error)
+	// tree returns the whole tree as an fs.FS, whose slash-separated paths
+	// are request paths without the leading slash.
+	tree() fs.FS
+	// id names the tree, distinctly from any other tree served alongside
+	// it, so trees sharing a fileCache keep their entries apart.
+	id() string
+	// serve writes the named file to ctx.
+	serve(ctx *fasthttp.RequestCtx, name string)
+}
+
+// diskFiles serves static assets from a directory on disk.
+type diskFiles struct {
+	root string
+}
+
+func (d diskFiles) resolve(reqPath string) (string, error) {
+	return resolveFile(d.root, reqPath)
+}
+
+func (d diskFiles) stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (d diskFiles) open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (d diskFiles) readDir(name string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	// Hide symlinks that are dangling or point outside the root, as
+	// following them would fail anyway
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSymlink != 0 {
+			target, err := filepath.EvalSymlinks(filepath.Join(name, entry.Name()))
+			if err != nil || !withinRoot(d.root, target) {
+				continue
+			}
+		}
+		kept = append(kept, entry)
+	}
+	return kept, nil
+}
+
+func (d diskFiles) tree() fs.FS {
+	return os.DirFS(d.root)
+}
+
+// id is the root itself, which is absolute and so cannot clash with the
+// embedded tree.
+func (d diskFiles) id() string {
+	return d.root
+}
+
+func (d diskFiles) serve(ctx *fasthttp.RequestCtx, name string) {
+	serveFile(ctx, name)
+}
+
+// fileHandler serves absolute paths produced by resolveFile. Unlike
+// fasthttp.ServeFile it never compresses, leaving that to withCompression,
+// and ignores Range, which staticServer handles itself. Its handle cache is
+// off, as a cached handle keeps serving a file's old contents and size for
+// seconds after it changes, under the ETag of the new ones.
+var fileHandler = (&fasthttp.FS{
+	Root:           "",
+	AllowEmptyRoot: true,
+	SkipCache:      true,
+}).NewRequestHandler()
+
+// staticServer serves request paths from a staticFiles tree, adding cache
+// validation and byte range support on top.
+type staticServer struct {
+	files staticFiles
+	// prefix is the URL path the files are mounted under, without a
+	// trailing slash, for redirects that must keep the prefix.
+	prefix       string
+	cacheControl string
+	// cache holds whole files in memory when -cache is set; nil otherwise
+	cache *fileCache
+	// dirListing lists directories that have no index file instead of
+	// answering 404
+	dirListing bool
+	// strictSlash redirects so directories always end in a slash and
+	// files never do
+	strictSlash bool
+	// streamThreshold is the size above which files are streamed by
+	// serveStream rather than the file handler; 0 disables streaming
+	streamThreshold int64
+}
+
+// serve writes the file for reqPath to ctx. It returns false without
+// touching ctx when no file matches, so the caller can fall back.
+func (s *staticServer) serve(ctx *fasthttp.RequestCtx, reqPath string) bool {
+	if s.cache != nil {
+		if e := s.cached(s.cacheKey(reqPath)); e != nil {
+			s.serveEntry(ctx, e)
+			return true
+		}
+	}
+
+	name, err := s.files.resolve(reqPath)
+	if errors.Is(err, errOutsideRoot) {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.SetBodyString("Forbidden")
+		return true
+	}
+	if s.strictSlash && (err == nil || errors.Is(err, errNoIndex)) && s.redirectSlash(ctx, reqPath, name, err) {
+		return true
+	}
+	if errors.Is(err, errNoIndex) && s.dirListing {
+		s.serveListing(ctx, reqPath, name)
+		return true
+	}
+	if err != nil {
+		return false
+	}
+
+	info, err := s.files.stat(name)
+	if err != nil {
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return true
+	}
+
+	if s.cache != nil && info.Size() <= s.cache.capacity {
+		// Files that can't be read whole are served uncached below
+		if e, err := s.load(s.cacheKey(reqPath), name, info); err == nil {
+			s.serveEntry(ctx, e)
+			return true
+		}
+	}
+
+	etag := fileETag(info)
+	if etagMatches(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), etag) {
+		ctx.NotModified()
+	} else if start, end, ok, err := parseRange(ctx.Request.Header.Peek(fasthttp.HeaderRange), info.Size()); err != nil {
+		unsatisfiableRange(ctx, info.Size())
+	} else if ok {
+		s.serveRange(ctx, name, info.Size(), start, end)
+	} else if ctx.IsHead() {
+		serveHead(ctx, contentType(name), info.Size(), info.ModTime())
+	} else if s.streamThreshold > 0 && info.Size() > s.streamThreshold {
+		s.serveStream(ctx, name, info)
+	} else {
+		s.files.serve(ctx, name)
+	}
+
+	// Set after serving: the file handler resets headers on its own 304s
+	s.setCacheHeaders(ctx, etag)
+	return true
+}
+
+// redirectSlash answers a 301 when the trailing slash of reqPath disagrees
+// with what it resolved to, name and err being the result of resolve. It
+// reports whether it redirected.
+func (s *staticServer) redirectSlash(ctx *fasthttp.RequestCtx, reqPath, name string, err error) bool {
+	if reqPath == "/" {
+		return false
+	}
+	trimmed := strings.TrimRight(reqPath, "/")
+	// resolve maps a directory onto its index, so an index the request did
+	// not name means a directory
+	isDir := errors.Is(err, errNoIndex) ||
+		(filepath.Base(name) == indexFile && path.Base(trimmed) != indexFile)
+
+	switch hasSlash := strings.HasSuffix(reqPath, "/"); {
+	case isDir && !hasSlash:
+		redirectTo(ctx, s.prefix+reqPath+"/")
+	case !isDir && hasSlash:
+		redirectTo(ctx, s.prefix+trimmed)
+	default:
+		return false
+	}
+	return true
+}
+
+// serveEntry writes a cached file, honouring the same conditional and
+// range headers as files served from disk.
+func (s *staticServer) serveEntry(ctx *fasthttp.RequestCtx, e *cacheEntry) {
+	size := int64(len(e.body))
+	if etagMatches(ctx.Request.Header.Peek(fasthttp.HeaderIfNoneMatch), e.etag) ||
+		(!e.modTime.IsZero() && !ctx.IfModifiedSince(e.modTime)) {
+		ctx.NotModified()
+	} else if start, end, ok, err := parseRange(ctx.Request.Header.Peek(fasthttp.HeaderRange), size); err != nil {
+		unsatisfiableRange(ctx, size)
+	} else if ok {
+		partialContent(ctx, e.contentType, start, end, size)
+		ctx.Response.SetBodyRaw(e.body[start : end+1])
+	} else {
+		// HEAD gets the body too: it is already in memory, fasthttp does not
+		// write it, and compressing it gives the same headers as a GET
+		ctx.SetContentType(e.contentType)
+		ctx.Response.SetBodyRaw(e.body)
+		if !e.modTime.IsZero() {
+			ctx.Response.Header.SetLastModified(e.modTime)
+		}
+	}
+	s.setCacheHeaders(ctx, e.etag)
+}
+
+// setCacheHeaders adds the caching headers to a successful response.
+func (s *staticServer) setCacheHeaders(ctx *fasthttp.RequestCtx, etag string) {
+	if ctx.Response.StatusCode() < fasthttp.StatusBadRequest {
+		ctx.Response.Header.Set(fasthttp.HeaderCacheControl, s.cacheControl)
+		ctx.Response.Header.Set(fasthttp.HeaderETag, etag)
+		ctx.Response.Header.Set(fasthttp.HeaderAcceptRanges, "bytes")
+	}
+}
+
+// serveRange writes bytes start through end (inclusive) of the named file
+// as a 206 Partial Content response.
+func (s *staticServer) serveRange(ctx *fasthttp.RequestCtx, name string, size, start, end int64) {
+	f, err := s.files.open(name)
+	if err != nil {
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return
+	}
+	seeker, ok := f.(io.Seeker)
+	if !ok {
+		f.Close()
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		f.Close()
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return
+	}
+
+	n := end - start + 1
+	partialContent(ctx, contentType(name), start, end, size)
+	// fasthttp closes the stream once the body has been written
+	ctx.SetBodyStream(readCloser{io.LimitReader(f, n), f}, int(n))
+}
+
+// serveStream writes the whole named file as a body stream read straight
+// from the open file, so memory stays bounded however large the file is
+// and however many clients fetch it at once.
+func (s *staticServer) serveStream(ctx *fasthttp.RequestCtx, name string, info fs.FileInfo) {
+	if mt := info.ModTime(); !mt.IsZero() && !ctx.IfModifiedSince(mt) {
+		ctx.NotModified()
+		return
+	}
+	f, err := s.files.open(name)
+	if err != nil {
+		ctx.Error("Internal Server Error", fasthttp.StatusInternalServerError)
+		return
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentType(name))
+	if mt := info.ModTime(); !mt.IsZero() {
+		ctx.Response.Header.SetLastModified(mt)
+	}
+	// The file is passed unwrapped so a disk file keeps sendfile; fasthttp
+	// closes it once the body is written or the response is discarded
+	ctx.SetBodyStream(f, int(info.Size()))
+}
+
+// serveHead answers a HEAD request with the headers a GET of the file
+// would get, leaving the body empty so the file is never opened.
+// withCompression adds the encoding headers a GET would get.
+func serveHead(ctx *fasthttp.RequestCtx, contentType string, size int64, modTime time.Time) {
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.SetContentType(contentType)
+	if !modTime.IsZero() {
+		ctx.Response.Header.SetLastModified(modTime)
+	}
+	// fasthttp keeps an explicit Content-Length on bodiless HEAD responses
+	ctx.Response.Header.SetContentLength(int(size))
+}
+
+// partialContent sets the status and headers of a 206 response for bytes
+// start through end of a size byte file.
+func partialContent(ctx *fasthttp.RequestCtx, contentType string, start, end, size int64) {
+	ctx.SetStatusCode(fasthttp.StatusPartialContent)
+	ctx.SetContentType(contentType)
+	ctx.Response.Header.SetContentRange(int(start), int(end), int(size))
+}
+
+// unsatisfiableRange writes a 416 response for a size byte file.
+func unsatisfiableRange(ctx *fasthttp.RequestCtx, size int64) {
+	ctx.Error("Range Not Satisfiable", fasthttp.StatusRequestedRangeNotSatisfiable)
+	ctx.Response.Header.Set(fasthttp.HeaderContentRange, "bytes */"+strconv.FormatInt(size, 10))
+}
+
+// readCloser pairs a reader that wraps a file with the file's Close.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contentType guesses a file's content type from its extension.
+func contentType(name string) string {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// errOutsideRoot is returned when a request path resolves outside the static root.
+var errOutsideRoot = errors.New("path escapes static root")
+
+// errNoIndex is returned for a directory without an index file. It matches
+// fs.ErrNotExist, since the request has nothing to serve by default.
+var errNoIndex = fmt.Errorf("directory has no %s: %w", indexFile, fs.ErrNotExist)
+
+// staticRoot returns the absolute, symlink-free form of dir so that it can be
+// compared against resolved request paths.
+func staticRoot(dir string) (string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	// A missing root is not fatal here; requests will simply 404
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	return root, nil
+}
+
+// checkRoot reports why files cannot be served from root, or nil if they
+// can: it must be a directory the server is able to list.
+func checkRoot(root string) error {
+	f, err := os.Open(root)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", root)
+	}
+	if _, err := f.ReadDir(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// resolvePath maps a request path onto a file under root. It rejects paths
+// that escape root either lexically (../) or through a symlink pointing
+// elsewhere. root must come from staticRoot.
+func resolvePath(root, reqPath string) (string, error) {
+	filePath := filepath.Join(root, filepath.FromSlash(reqPath))
+	if !withinRoot(root, filePath) {
+		return "", errOutsideRoot
+	}
+
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return "", err
+	}
+	if !withinRoot(root, resolved) {
+		return "", errOutsideRoot
+	}
+	return resolved, nil
+}
+
+// resolveFile is like resolvePath but maps a directory onto its index file.
+// It returns the directory with errNoIndex when it has no index file.
+func resolveFile(root, reqPath string) (string, error) {
+	filePath, err := resolvePath(root, reqPath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return filePath, nil
+	}
+
+	// Resolve the index through resolvePath so it gets the same symlink check
+	indexPath, err := resolvePath(root, path.Join(reqPath, indexFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return filePath, errNoIndex
+	}
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(indexPath); err != nil || info.IsDir() {
+		return filePath, errNoIndex
+	}
+	return indexPath, nil
+}
+
+// serveFile writes the file at filePath, which must come from resolveFile.
+func serveFile(ctx *fasthttp.RequestCtx, filePath string) {
+	ctx.Request.SetRequestURI(escapePath(filepath.ToSlash(filePath)))
+	fileHandler(ctx)
+}
+
+// escapePath percent-encodes a file path for use as a request URI, so
+// names containing characters such as ?, # or % round-trip intact.
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// redirectTo answers a 301 to urlPath, keeping the request's query string.
+func redirectTo(ctx *fasthttp.RequestCtx, urlPath string) {
+	location := escapePath(urlPath)
+	if query := ctx.URI().QueryString(); len(query) > 0 {
+		location += "?" + string(query)
+	}
+	ctx.Redirect(location, fasthttp.StatusMovedPermanently)
+}
+
+// fileETag derives a strong ETag from a file's modification time and size,
+// in the same style as nginx.
+func fileETag(info fs.FileInfo) string {
+	// Embedded files have no modification time; keep their tag non-negative
+	var modTime int64
+	if mt := info.ModTime(); !mt.IsZero() {
+		modTime = mt.Unix()
+	}
+	return `"` + strconv.FormatInt(modTime, 16) + "-" + strconv.FormatInt(info.Size(), 16) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches etag.
+// Per RFC 9110 the comparison is weak, so a W/ prefix is ignored.
+func etagMatches(ifNoneMatch []byte, etag string) bool {
+	for _, candidate := range bytes.Split(ifNoneMatch, []byte(",")) {
+		candidate = bytes.TrimPrefix(bytes.TrimSpace(candidate), []byte("W/"))
+		if string(candidate) == "*" || string(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// withinRoot reports whether path is root itself or lies beneath it.
+func withinRoot(root, path string) bool {
+	if path == root {
+		return true
+	}
+	prefix := root
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, prefix)
+}
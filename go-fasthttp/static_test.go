@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// traversalDir returns a directory whose public holds a.txt, beside a
+// secret.txt outside it, with symlinks both inside public and out of it.
+func traversalDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"secret.txt":        "secret",
+		"public/a.txt":      "hello",
+		"public/sub/b.txt":  "b",
+		"outside/other.txt": "other",
+	})
+	for link, target := range map[string]string{
+		"public/in.txt":   "a.txt",
+		"public/out.txt":  filepath.Join("..", "secret.txt"),
+		"public/outdir":   filepath.Join(dir, "outside"),
+		"public/sub/up":   filepath.Join("..", ".."),
+		"public/dangling": "nope.txt",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestResolvePath(t *testing.T) {
+	root, err := staticRoot(filepath.Join(traversalDir(t), "public"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		reqPath string
+		want    string // relative to root, for paths that resolve
+		err     error
+	}{
+		{reqPath: "/a.txt", want: "a.txt"},
+		{reqPath: "/sub/../a.txt", want: "a.txt"},
+		{reqPath: "/in.txt", want: "a.txt"},
+		{reqPath: "/../secret.txt", err: errOutsideRoot},
+		{reqPath: "/sub/../../secret.txt", err: errOutsideRoot},
+		{reqPath: "../../../../etc/passwd", err: errOutsideRoot},
+		// Absolute paths are taken relative to the root
+		{reqPath: "/etc/passwd", err: fs.ErrNotExist},
+		{reqPath: "/out.txt", err: errOutsideRoot},
+		{reqPath: "/outdir/other.txt", err: errOutsideRoot},
+		{reqPath: "/sub/up/secret.txt", err: errOutsideRoot},
+		{reqPath: "/dangling", err: fs.ErrNotExist},
+	} {
+		got, err := resolvePath(root, tc.reqPath)
+		if tc.err != nil {
+			if !errors.Is(err, tc.err) {
+				t.Errorf("resolvePath(%q) = %q, %v; want error %v", tc.reqPath, got, err, tc.err)
+			}
+			continue
+		}
+		if want := filepath.Join(root, tc.want); err != nil || got != want {
+			t.Errorf("resolvePath(%q) = %q, %v; want %q", tc.reqPath, got, err, want)
+		}
+	}
+}
+
+func TestWithinRoot(t *testing.T) {
+	root := filepath.Join(string(filepath.Separator), "srv", "public")
+	for path, want := range map[string]bool{
+		root:                                true,
+		filepath.Join(root, "a.txt"):        true,
+		filepath.Join(root, "sub", "b.txt"): true,
+		root + "-private":                   false,
+		filepath.Dir(root):                  false,
+		filepath.Join(root, "..", "x"):      false,
+	} {
+		if got := withinRoot(root, path); got != want {
+			t.Errorf("withinRoot(%q, %q) = %v, want %v", root, path, got, want)
+		}
+	}
+}
+
+func TestStaticTraversalRequests(t *testing.T) {
+	urls, _ := startServer(t, traversalDir(t))
+
+	for path, status := range map[string]int{
+		"/a.txt":  fasthttp.StatusOK,
+		"/in.txt": fasthttp.StatusOK,
+		// fasthttp decodes and cleans these to /secret.txt inside the root
+		"/../secret.txt":            fasthttp.StatusNotFound,
+		"/%2e%2e/secret.txt":        fasthttp.StatusNotFound,
+		"/..%2fsecret.txt":          fasthttp.StatusNotFound,
+		"/sub/..%2f..%2fsecret.txt": fasthttp.StatusNotFound,
+		"/%2e%2e%2fsecret.txt":      fasthttp.StatusNotFound,
+		// Symlinks out of the root are refused outright
+		"/out.txt":           fasthttp.StatusForbidden,
+		"/outdir/other.txt":  fasthttp.StatusForbidden,
+		"/sub/up/secret.txt": fasthttp.StatusForbidden,
+	} {
+		resp := get(t, urls[0]+path)
+		if body := string(resp.Body()); body == "secret" || body == "other" {
+			t.Errorf("GET %s served %q from outside the root", path, body)
+		}
+		if resp.StatusCode() != status {
+			t.Errorf("GET %s: status %d, want %d", path, resp.StatusCode(), status)
+		}
+	}
+}
+
+func TestDirectoryIndex(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"public/index.html":      "home",
+		"public/docs/index.html": "docs",
+		"public/empty/a.txt":     "a",
+	})
+	urls, _ := startServer(t, dir)
+
+	for path, want := range map[string]string{
+		"/":       "home",
+		"/docs":   "docs",
+		"/docs/":  "docs",
+		"/empty/": "Not found",
+		"/nope/":  "Not found",
+	} {
+		if resp := get(t, urls[0]+path); string(resp.Body()) != want {
+			t.Errorf("GET %s: status %d, body %q; want %q", path, resp.StatusCode(), resp.Body(), want)
+		}
+	}
+
+	// Without public/index.html, / keeps its built-in answer
+	urls, _ = startServer(t, t.TempDir())
+	if resp := get(t, urls[0]+"/"); resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "Go!" {
+		t.Errorf("GET / without an index: status %d, body %q; want 200 Go!", resp.StatusCode(), resp.Body())
+	}
+}
+
+func TestStaticNotModified(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"public/a.txt": "hello"})
+	urls, _ := startServer(t, dir, "-max-age", "60")
+
+	resp := get(t, urls[0]+"/a.txt")
+	etag := string(resp.Header.Peek(fasthttp.HeaderETag))
+	if resp.StatusCode() != fasthttp.StatusOK || string(resp.Body()) != "hello" || etag == "" {
+		t.Fatalf("first GET: status %d, body %q, ETag %q", resp.StatusCode(), resp.Body(), etag)
+	}
+	if got := string(resp.Header.Peek(fasthttp.HeaderCacheControl)); got != "public, max-age=60" {
+		t.Errorf("Cache-Control = %q, want public, max-age=60", got)
+	}
+
+	resp = get(t, urls[0]+"/a.txt", fasthttp.HeaderIfNoneMatch, etag)
+	if resp.StatusCode() != fasthttp.StatusNotModified || len(resp.Body()) != 0 {
+		t.Fatalf("revalidation: status %d, body %q, want 304 and no body", resp.StatusCode(), resp.Body())
+	}
+	if got := string(resp.Header.Peek(fasthttp.HeaderETag)); got != etag {
+		t.Errorf("304 ETag = %q, want %q", got, etag)
+	}
+
+	resp = get(t, urls[0]+"/a.txt", fasthttp.HeaderIfNoneMatch, `"stale"`)
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Errorf("non-matching If-None-Match: status %d, want 200", resp.StatusCode())
+	}
+	if resp := get(t, urls[0]+"/missing"); resp.Header.Peek(fasthttp.HeaderETag) != nil {
+		t.Errorf("404 carries ETag %q", resp.Header.Peek(fasthttp.HeaderETag))
+	}
+}
+
+func TestStaticRewrittenFile(t *testing.T) {
+	s, root := testStatic(t, map[string]string{"mut.txt": "version-one!"})
+	c := testClient(t, staticHandler(s))
+
+	first := fetch(t, c, fasthttp.MethodGet, "/mut.txt")
+	if err := os.WriteFile(filepath.Join(root, "mut.txt"), []byte("version-two-longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Served straight after the rewrite, well within any handle cache
+	second := fetch(t, c, fasthttp.MethodGet, "/mut.txt")
+
+	if got := string(second.Body()); got != "version-two-longer" {
+		t.Errorf("body after rewrite = %q, want the new contents", got)
+	}
+	if second.Header.ContentLength() != len("version-two-longer") {
+		t.Errorf("Content-Length after rewrite = %d", second.Header.ContentLength())
+	}
+	if string(first.Header.Peek(fasthttp.HeaderETag)) == string(second.Header.Peek(fasthttp.HeaderETag)) {
+		t.Errorf("ETag %q unchanged by rewrite", second.Header.Peek(fasthttp.HeaderETag))
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"5f1-2a"`
+	for header, want := range map[string]bool{
+		`"5f1-2a"`:             true,
+		`W/"5f1-2a"`:           true,
+		`"other", "5f1-2a"`:    true,
+		`"other",W/"5f1-2a" `:  true,
+		`*`:                    true,
+		`"other"`:              false,
+		`5f1-2a`:               false,
+		``:                     false,
+		`"5f1-2a-gzip", "5f1"`: false,
+	} {
+		if got := etagMatches([]byte(header), etag); got != want {
+			t.Errorf("etagMatches(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestStrictSlash(t *testing.T) {
+	s, _ := testStatic(t, map[string]string{
+		"index.html":      "home",
+		"a.txt":           "a",
+		"docs/index.html": "docs",
+		"empty/.keep":     "",
+	})
+	s.strictSlash = true
+	c := testClient(t, staticHandler(s))
+
+	for _, tc := range []struct {
+		path     string
+		status   int
+		location string // for redirects
+		body     string // otherwise
+	}{
+		{path: "/docs", status: fasthttp.StatusMovedPermanently, location: "/docs/"},
+		{path: "/docs?x=1&y=2", status: fasthttp.StatusMovedPermanently, location: "/docs/?x=1&y=2"},
+		{path: "/empty", status: fasthttp.StatusMovedPermanently, location: "/empty/"},
+		{path: "/a.txt/", status: fasthttp.StatusMovedPermanently, location: "/a.txt"},
+		{path: "/a.txt/?v=3", status: fasthttp.StatusMovedPermanently, location: "/a.txt?v=3"},
+		// Paths whose slash already agrees are served as they are
+		{path: "/", status: fasthttp.StatusOK, body: "home"},
+		{path: "/docs/", status: fasthttp.StatusOK, body: "docs"},
+		{path: "/docs/index.html", status: fasthttp.StatusOK, body: "docs"},
+		{path: "/a.txt", status: fasthttp.StatusOK, body: "a"},
+		{path: "/empty/", status: fasthttp.StatusNotFound, body: "Not found"},
+		{path: "/missing", status: fasthttp.StatusNotFound, body: "Not found"},
+		{path: "/missing/", status: fasthttp.StatusNotFound, body: "Not found"},
+	} {
+		resp := fetch(t, c, fasthttp.MethodGet, tc.path)
+		if resp.StatusCode() != tc.status {
+			t.Errorf("GET %s: status %d, want %d", tc.path, resp.StatusCode(), tc.status)
+			continue
+		}
+		if tc.location != "" {
+			// The client makes the Location absolute
+			if got := string(resp.Header.Peek(fasthttp.HeaderLocation)); got != "http://test"+tc.location {
+				t.Errorf("GET %s: Location %q, want %q", tc.path, got, tc.location)
+			}
+		} else if string(resp.Body()) != tc.body {
+			t.Errorf("GET %s: body %q, want %q", tc.path, resp.Body(), tc.body)
+		}
+	}
+}
+
+// openFiles counts the file descriptors this process holds, or returns -1
+// where /proc does not list them.
+func openFiles() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+func TestStreamLargeFile(t *testing.T) {
+	const size, clients = 4 << 20, 16
+	big := make([]byte, size)
+	for i := range big {
+		big[i] = byte(i * 7 % 251)
+	}
+	want := sha256.Sum256(big)
+	s, _ := testStatic(t, map[string]string{"big.bin": string(big)})
+	s.streamThreshold = 64 << 10
+	big = nil
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fasthttp.Server{Handler: staticHandler(s)}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Shutdown() })
+
+	fdsBefore := openFiles()
+	runtime.GC()
+	var before, during runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	// Every client reads its headers and then pauses, so all the responses
+	// are in flight together while the heap is measured
+	var started, paused sync.WaitGroup
+	release := make(chan struct{})
+	errs := make(chan error, clients)
+	started.Add(clients)
+	paused.Add(clients)
+	for i := 0; i < clients; i++ {
+		go func() {
+			defer paused.Done()
+			errs <- fetchStreamed(ln.Addr().String(), size, want, started.Done, release)
+		}()
+	}
+	started.Wait()
+	runtime.GC()
+	runtime.ReadMemStats(&during)
+	close(release)
+	paused.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Buffering the file would hold a copy per client; streaming holds none
+	if grew := int64(during.HeapAlloc) - int64(before.HeapAlloc); grew > clients*size/8 {
+		t.Errorf("heap grew by %d bytes with %d responses of %d bytes in flight", grew, clients, size)
+	}
+
+	if fdsBefore < 0 {
+		return
+	}
+	// The server closes each file once its response is written
+	for deadline := time.Now().Add(5 * time.Second); openFiles() > fdsBefore; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d file descriptors open after streaming, %d before", openFiles(), fdsBefore)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fetchStreamed requests /big.bin from addr on a connection of its own,
+// calling ready once the headers are read and waiting for release before
+// reading a body of size bytes, which must hash to want.
+func fetchStreamed(addr string, size int, want [sha256.Size]byte, ready func(), release <-chan struct{}) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		ready()
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	fmt.Fprint(conn, "GET /big.bin HTTP/1.1\r\nHost: test\r\nConnection: close\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	var header fasthttp.ResponseHeader
+	err = header.Read(r)
+	ready()
+	if err != nil {
+		return err
+	}
+	if header.StatusCode() != fasthttp.StatusOK || header.ContentLength() != size {
+		return fmt.Errorf("status %d, Content-Length %d; want 200 and %d", header.StatusCode(), header.ContentLength(), size)
+	}
+
+	<-release
+	h := sha256.New()
+	if n, err := io.Copy(h, r); err != nil || n != int64(size) {
+		return fmt.Errorf("read %d of %d body bytes: %v", n, size, err)
+	}
+	if !bytes.Equal(h.Sum(nil), want[:]) {
+		return errors.New("body does not match the file")
+	}
+	return nil
+}
+
+// countingFiles is a staticFiles tree that counts the files it opens or
+// serves.
+type countingFiles struct {
+	staticFiles
+	opened atomic.Int32
+}
+
+func (c *countingFiles) open(name string) (fs.File, error) {
+	c.opened.Add(1)
+	return c.staticFiles.open(name)
+}
+
+func (c *countingFiles) serve(ctx *fasthttp.RequestCtx, name string) {
+	c.opened.Add(1)
+	c.staticFiles.serve(ctx, name)
+}
+
+func TestHeadDoesNotOpen(t *testing.T) {
+	const body = "hello, world"
+	for _, tc := range []struct {
+		name            string
+		streamThreshold int64
+	}{
+		{"served", 0},
+		{"streamed", 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, root := testStatic(t, map[string]string{"a.txt": body})
+			files := &countingFiles{staticFiles: s.files}
+			s.files = files
+			s.streamThreshold = tc.streamThreshold
+			c := testClient(t, staticHandler(s))
+
+			resp := fetch(t, c, fasthttp.MethodHead, "/a.txt")
+			if resp.StatusCode() != fasthttp.StatusOK || resp.Header.ContentLength() != len(body) {
+				t.Errorf("HEAD: status %d, Content-Length %d; want 200 and %d", resp.StatusCode(), resp.Header.ContentLength(), len(body))
+			}
+			info, err := os.Stat(filepath.Join(root, "a.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(resp.Header.Peek(fasthttp.HeaderLastModified)); got != info.ModTime().UTC().Format(http.TimeFormat) {
+				t.Errorf("HEAD: Last-Modified %q", got)
+			}
+			if n := files.opened.Load(); n != 0 {
+				t.Errorf("HEAD opened the file %d times", n)
+			}
+
+			if resp := fetch(t, c, fasthttp.MethodGet, "/a.txt"); string(resp.Body()) != body || files.opened.Load() == 0 {
+				t.Errorf("GET: body %q, opened %d times", resp.Body(), files.opened.Load())
+			}
+		})
+	}
+}
+
+func TestHeadMatchesGet(t *testing.T) {
+	big := strings.Repeat("compressible text ", 400)
+	headers := []string{
+		fasthttp.HeaderContentType,
+		fasthttp.HeaderContentLength,
+		fasthttp.HeaderContentEncoding,
+		fasthttp.HeaderTransferEncoding,
+		fasthttp.HeaderVary,
+		fasthttp.HeaderETag,
+		fasthttp.HeaderLastModified,
+		fasthttp.HeaderCacheControl,
+		fasthttp.HeaderAcceptRanges,
+	}
+
+	for _, tc := range []struct {
+		name   string
+		tune   func(*staticServer)
+		accept string
+	}{
+		{name: "identity"},
+		{name: "gzip", accept: "gzip"},
+		{name: "br", accept: "br, gzip"},
+		{name: "streamed gzip", accept: "gzip", tune: func(s *staticServer) { s.streamThreshold = 1024 }},
+		{name: "cached gzip", accept: "gzip", tune: func(s *staticServer) { s.cache = newFileCache(1 << 20) }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := testStatic(t, map[string]string{"big.txt": big})
+			if tc.tune != nil {
+				tc.tune(s)
+			}
+			c := testClient(t, withCompression(staticHandler(s)))
+
+			for _, path := range []string{"/big.txt", "/missing.txt"} {
+				get := fetch(t, c, fasthttp.MethodGet, path, fasthttp.HeaderAcceptEncoding, tc.accept)
+				head := fetch(t, c, fasthttp.MethodHead, path, fasthttp.HeaderAcceptEncoding, tc.accept)
+				if head.StatusCode() != get.StatusCode() {
+					t.Errorf("HEAD %s status %d, GET %d", path, head.StatusCode(), get.StatusCode())
+				}
+				if len(head.Body()) != 0 {
+					t.Errorf("HEAD %s has a %d byte body", path, len(head.Body()))
+				}
+				for _, name := range headers {
+					h, g := head.Header.Peek(name), get.Header.Peek(name)
+					// A compressed stream's length is unknown until it is
+					// sent, so HEAD leaves out how it will be framed
+					framing := name == fasthttp.HeaderContentLength || name == fasthttp.HeaderTransferEncoding
+					if framing && get.Header.ContentLength() == -1 {
+						// The client reads a missing length as identity
+						if head.Header.ContentLength() != -2 {
+							t.Errorf("HEAD %s %s = %q, want none for a chunked GET", path, name, h)
+						}
+						continue
+					}
+					if string(h) != string(g) {
+						t.Errorf("HEAD %s %s = %q, GET %q", path, name, h, g)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+// statusPath serves a plain-text summary of the server for humans, next to
+// the Prometheus counters at /metrics.
+const statusPath = "/status"
+
+// writeStatus renders the uptime and counters of m along with whether each
+// root can be served. A root's directory stays out of the page, which would
+// otherwise tell any visitor where the files live on disk; a root with an
+// empty dir is the embedded public tree.
+func writeStatus(w io.Writer, m *metrics, roots []rootMapping) {
+	fmt.Fprintf(w, "uptime:       %s\n", time.Since(m.start).Round(time.Second))
+	fmt.Fprintf(w, "requests:     %d\n", m.requests.Load())
+	fmt.Fprintf(w, "bytes served: %d\n", m.bytes.Load())
+	fmt.Fprintf(w, "goroutines:   %d\n", runtime.NumGoroutine())
+	for _, root := range roots {
+		prefix := root.prefix
+		if prefix == "" {
+			prefix = "/"
+		}
+		state := "embedded"
+		if root.dir != "" {
+			state = "ok"
+			if checkRoot(root.dir) != nil {
+				state = "unavailable"
+			}
+		}
+		fmt.Fprintf(w, "root %s: %s\n", prefix, state)
+	}
+}
+
+// serveStatus serves the status page from m. Like /metrics it is a route,
+// so it sits behind the IP filter and Basic Auth, and withMetrics leaves it
+// out so viewing the page does not change the counts it shows.
+func serveStatus(m *metrics, roots []rootMapping) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		ctx.SetContentType("text/plain; charset=utf-8")
+		ctx.SetStatusCode(fasthttp.StatusOK)
+		writeStatus(ctx, m, roots)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestStatusCounters(t *testing.T) {
+	s, root := testStatic(t, map[string]string{"a.txt": "hello"})
+	m := newMetrics()
+	roots := []rootMapping{{dir: root}, {prefix: "/gone", dir: filepath.Join(root, "gone")}, {prefix: "/embedded"}}
+	r := NewRouter()
+	r.GET(statusPath, serveStatus(m, roots))
+	r.NotFound = staticHandler(s)
+	ln := testListener(t, withMetrics(r.Handler(), m))
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wire := &countingReader{r: conn}
+	br := bufio.NewReader(wire)
+	// Requests go one at a time on one connection, so each response is
+	// read in full before the next is sent and the count is exact
+	get := func(path string) *fasthttp.Response {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: test\r\n\r\n", path)
+		resp := &fasthttp.Response{}
+		if err := resp.Read(br); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp
+	}
+
+	get("/a.txt")
+	get("/a.txt")
+	get("/missing.txt")
+	sent := wire.n
+	resp := get(statusPath)
+	if resp.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode())
+	}
+
+	page := string(resp.Body())
+	for _, want := range []struct {
+		field string
+		value int
+	}{
+		{"requests", 3},
+		{"bytes served", sent},
+	} {
+		match := regexp.MustCompile(`(?m)^` + want.field + `: +(\d+)$`).FindStringSubmatch(page)
+		if match == nil {
+			t.Errorf("no %s line in\n%s", want.field, page)
+			continue
+		}
+		if got, _ := strconv.Atoi(match[1]); got != want.value {
+			t.Errorf("%s = %d, want %d", want.field, got, want.value)
+		}
+	}
+	for _, want := range []string{"root /: ok", "root /gone: unavailable", "root /embedded: embedded"} {
+		if !regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(want) + `$`).MatchString(page) {
+			t.Errorf("no %q line in\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, root) {
+		t.Errorf("status page shows the root directory %s:\n%s", root, page)
+	}
+}
+
+func TestStatusBehindFilters(t *testing.T) {
+	t.Run("ip filter", func(t *testing.T) {
+		urls, _ := startServer(t, t.TempDir(), "-deny-cidr", "127.0.0.1")
+		for _, path := range []string{statusPath, metricsPath} {
+			if resp := get(t, urls[0]+path); resp.StatusCode() != fasthttp.StatusForbidden {
+				t.Errorf("denied client: GET %s got %d, want 403", path, resp.StatusCode())
+			}
+		}
+	})
+
+	t.Run("auth and security headers", func(t *testing.T) {
+		urls, _ := startServer(t, t.TempDir(),
+			"-auth-prefix", "/", "-auth-user", "alice", "-auth-pass", "s3cret", "-security-headers")
+		credentials := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret"))
+		for _, path := range []string{statusPath, metricsPath} {
+			resp := get(t, urls[0]+path)
+			if resp.StatusCode() != fasthttp.StatusUnauthorized {
+				t.Errorf("GET %s without credentials: status %d, want 401", path, resp.StatusCode())
+			}
+			resp = get(t, urls[0]+path, fasthttp.HeaderAuthorization, credentials)
+			if resp.StatusCode() != fasthttp.StatusOK {
+				t.Errorf("GET %s with credentials: status %d, want 200", path, resp.StatusCode())
+			}
+			if got := string(resp.Header.Peek("X-Content-Type-Options")); got != "nosniff" {
+				t.Errorf("GET %s: X-Content-Type-Options %q, want nosniff", path, got)
+			}
+		}
+	})
+}
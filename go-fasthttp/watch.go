@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchRoots drops cache entries as soon as their files change on disk,
+// instead of at the next revalidation. fsnotify watches single
+// directories, so every directory below each root is watched, including
+// ones created later.
+func watchRoots(cache *fileCache, roots []string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		// A missing root serves nothing, so there is nothing to invalidate
+		if err := watchTree(w, root); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			w.Close()
+			return err
+		}
+	}
+
+	go func() {
+		for {
+			select {
+			case ev, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if ev.Op == fsnotify.Chmod {
+					continue
+				}
+				// Renames and removals of a directory arrive once, for the
+				// directory, so this also covers the files inside it
+				cache.removeFile(ev.Name)
+				if ev.Has(fsnotify.Create) {
+					if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+						if err := watchTree(w, ev.Name); err != nil {
+							log.Printf("Error watching %s: %v", ev.Name, err)
+						}
+					}
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Error watching static files: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// watchTree adds dir and every directory below it to w.
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.Add(path)
+		}
+		return nil
+	})
+}
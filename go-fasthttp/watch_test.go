@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestWatchRoots(t *testing.T) {
+	s, root := testStatic(t, map[string]string{"a.txt": "one"})
+	s.cache = newFileCache(1 << 20)
+	if err := watchRoots(s.cache, []string{root}); err != nil {
+		t.Fatal(err)
+	}
+	c := testClient(t, staticHandler(s))
+
+	// expectSoon fails unless path serves want before the cache would have
+	// revalidated it by itself
+	expectSoon := func(path, want string) {
+		t.Helper()
+		deadline := time.Now().Add(cacheRevalidateInterval / 2)
+		for {
+			body := string(fetch(t, c, fasthttp.MethodGet, path).Body())
+			if body == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s still serves %q, want %q", path, body, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	expectSoon("/a.txt", "one")
+	if s.cache.get(s.cacheKey("/a.txt")) == nil {
+		t.Fatal("/a.txt not cached after being served")
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectSoon("/a.txt", "two")
+
+	// Directories created after startup are watched too
+	writeFiles(t, root, map[string]string{"new/b.txt": "first"})
+	expectSoon("/new/b.txt", "first")
+	// Give the watcher time to add the new directory before changing it
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(filepath.Join(root, "new", "b.txt"), []byte("later"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	expectSoon("/new/b.txt", "later")
+}